@@ -0,0 +1,23 @@
+package authenticate
+
+import (
+	"bookman/db"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DeleteAccount removes the user once its password is confirmed. A wrong
+// password counts as a failed login of the user from the IP address.
+func (a *Auth) DeleteAccount(user *db.User, password, ip string) error {
+	at, err := a.beginAttempt(Credentials{Username: user.Username, IP: ip}, a.now())
+	if err != nil {
+		return err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if err != nil {
+		return ErrWrongPassword
+	}
+	// only a login forgives the earlier failures
+	a.endAttempt(at)
+	return a.db.DeleteUser(user.ID)
+}
@@ -3,83 +3,243 @@ package authenticate
 import (
 	"bookman/db"
 	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"strconv"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 type Auth struct {
-	db                    *db.GormDB
-	logger                *logrus.Logger
-	jwtExpirationDuration time.Duration
-	secretKey             []byte
+	db     db.Store
+	logger *logrus.Logger
+	keys   *KeySet
+	tokens TokenConfig
+	// now is the clock tokens are issued and validated with
+	now func() time.Time
+	// limits slow down password guessing, see LimitLogins
+	limits LoginLimits
+	// resetLimits throttle the requests for reset codes, see LimitResetRequests
+	resetLimits ResetLimits
+	// dummyHash is compared with the password of an unknown username
+	dummyHash []byte
 }
 
-func NewAuth(db *db.GormDB, logger *logrus.Logger, jwtExpirationDuration time.Duration) (*Auth, error) {
-	secretKey, err := generateRandomKey()
-	if err != nil {
-		return nil, err
-	}
+// TokenConfig describes the registered claims of the issued tokens
+type TokenConfig struct {
+	Issuer   string
+	Audience string
+	// Lifetime is how long an access token stays valid
+	Lifetime time.Duration
+	// RefreshLifetime is how long a refresh token stays valid
+	RefreshLifetime time.Duration
+	// ClockSkew is the leeway given when validating exp, nbf and iat
+	ClockSkew time.Duration
+	// ResetLifetime is how long a password reset code stays valid
+	ResetLifetime time.Duration
+	// ChallengeLifetime is how long a user has to give the second factor
+	// after the password
+	ChallengeLifetime time.Duration
+}
 
+var ErrWrongPassword = errors.New("the password is not correct")
+
+// NewAuth creates an Auth signing tokens with the given keys. Without keys a
+// random key is generated, so tokens do not survive a restart.
+func NewAuth(db db.Store, logger *logrus.Logger, keys *KeySet, tokens TokenConfig) (*Auth, error) {
 	if db == nil {
 		return nil, errors.New("database can not be nil")
 	}
+	if tokens.Lifetime <= 0 || tokens.RefreshLifetime <= 0 || tokens.ResetLifetime <= 0 || tokens.ChallengeLifetime <= 0 {
+		return nil, errors.New("the token lifetimes must be positive")
+	}
+
+	if keys == nil {
+		logger.Warn("no signing key is configured, tokens will be invalid after a restart")
+		var err error
+		keys, err = NewRandomKeySet()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a hash of a random password, made with the cost of the stored ones
+	secret, err := generateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	dummyHash, err := db.HashPassword(hex.EncodeToString(secret))
+	if err != nil {
+		return nil, err
+	}
 
 	return &Auth{
-		db:                    db,
-		logger:                logger,
-		jwtExpirationDuration: jwtExpirationDuration,
-		secretKey:             secretKey,
+		db:        db,
+		logger:    logger,
+		keys:      keys,
+		tokens:    tokens,
+		now:       time.Now,
+		dummyHash: []byte(dummyHash),
 	}, nil
 }
 
 type Credentials struct {
 	Username string
 	Password string
+	// IP is the address of the client, its failed logins are counted too
+	IP string
 }
 
 type Token struct {
 	TokenString string
+	ExpiresAt   time.Time
+	// ExpiresIn is how long the token is valid, measured on the clock of Auth
+	ExpiresIn time.Duration
+	// RefreshToken is exchanged once for a new pair of tokens by Refresh
+	RefreshToken string
 }
 
 type claims struct {
-	jwt.MapClaims
+	jwt.RegisteredClaims
 	Username string `json:"username"`
+	// SessionID is the family of the refresh tokens issued with the token
+	SessionID string `json:"sid"`
+	// Version is the token version of the user when the token was issued
+	Version uint   `json:"ver"`
+	Role    string `json:"role"`
 }
 
+// Validate requires the claims the parser only checks when they are present
+func (c *claims) Validate() error {
+	if c.ExpiresAt == nil || c.IssuedAt == nil {
+		return errors.New("the token has no expiration or issue time")
+	}
+	if c.Subject == "" || c.Username == "" {
+		return errors.New("the token has no subject")
+	}
+	if c.SessionID == "" {
+		return errors.New("the token has no session")
+	}
+	return nil
+}
+
+// Login checks the credentials and starts a new session. An unknown username
+// and a wrong password both give ErrInvalidCredentials after the same bcrypt
+// work, and while earlier attempts block the username or the IP address a
+// *LockoutError is returned without checking the password. A user with
+// two-factor authentication gets a *SecondFactorRequiredError holding the
+// challenge to complete with LoginSecondFactor.
 func (a *Auth) Login(cred Credentials) (*Token, error) {
+	at, err := a.beginAttempt(cred, a.now())
+	if err != nil {
+		return nil, err
+	}
 
-	// Check existence of user
+	// Check existence of user, nobody may log in as the owner of the books
+	// of deleted users. A failed attempt stays counted.
 	account, err := a.db.GetUserByUsername(cred.Username)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && account.Username == db.GhostUsername) {
+		a.compareDummyHash(cred.Password)
+		return nil, ErrInvalidCredentials
+	}
 	if err != nil {
+		a.endAttempt(at)
 		return nil, err
 	}
 
 	// Check password
 	err = bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(cred.Password))
 	if err != nil {
-		return nil, errors.New("the password is not correct")
+		return nil, ErrInvalidCredentials
+	}
+
+	// Hash the password again when the configured cost changed since
+	if a.db.PasswordNeedsRehash(account.Password) {
+		if err = a.db.SetUserPassword(account.ID, cred.Password); err != nil {
+			a.logger.WithError(err).Warn("can not rehash the password of user ", account.ID)
+		}
+	}
+
+	// The password is not enough when the user enabled a second factor, the
+	// failures stay until the second factor is given too
+	twoFactor, err := a.db.GetTwoFactor(account.ID)
+	if err == nil && twoFactor.ConfirmedAt != nil {
+		a.endAttempt(at)
+		return nil, a.issueChallenge(account)
+	}
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		a.endAttempt(at)
+		return nil, err
+	}
+	a.attemptSucceeded(at)
+
+	// Start a new family of refresh tokens for this login
+	familyID, err := generateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return a.issueTokens(account, hex.EncodeToString(familyID))
+}
+
+// compareDummyHash spends the time of checking a password, so an unknown
+// username can not be told apart by the response time
+func (a *Auth) compareDummyHash(password string) {
+	bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+}
+
+// issueTokens creates an access token and the next refresh token of the family
+func (a *Auth) issueTokens(user *db.User, familyID string) (*Token, error) {
+	token, err := a.issueAccessToken(user, familyID)
+	if err != nil {
+		return nil, err
+	}
+	token.RefreshToken, err = a.newRefreshToken(user.ID, familyID)
+	if err != nil {
+		return nil, err
 	}
+	return token, nil
+}
 
-	//	Create JWT token
-	expirationTime := time.Now().Add(a.jwtExpirationDuration)
+// issueAccessToken creates an access token of the session the refresh token
+// family stands for
+func (a *Auth) issueAccessToken(user *db.User, familyID string) (*Token, error) {
+	//	Create JWT token, with a unique ID even when issued in the same second
+	tokenID, err := generateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	now := a.now()
+	expirationTime := now.Add(a.tokens.Lifetime)
 	tokenJWT := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims{
-		MapClaims: jwt.MapClaims{
-			"expired": expirationTime.Unix(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(tokenID),
+			Issuer:    a.tokens.Issuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  jwt.ClaimStrings{a.tokens.Audience},
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
-		Username: cred.Username,
+		Username:  user.Username,
+		SessionID: familyID,
+		Version:   user.TokenVersion,
+		Role:      user.Role,
 	})
 
-	tokenString, err := tokenJWT.SignedString(a.secretKey)
+	key := a.keys.Active()
+	tokenJWT.Header["kid"] = key.ID
+	tokenString, err := tokenJWT.SignedString(key.Secret)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Token{
 		TokenString: tokenString,
+		ExpiresAt:   expirationTime,
+		ExpiresIn:   expirationTime.Sub(now),
 	}, nil
 }
 
@@ -87,29 +247,32 @@ func (a *Auth) GenerateToken(cred Credentials) (Token, error) {
 	return Token{}, nil
 }
 
-func (a *Auth) GetAccountByToken(token string) (*string, error) {
+// GetAccountByToken returns the user of a valid access token which is not
+// revoked
+func (a *Auth) GetAccountByToken(token string) (*db.User, error) {
 	//	Handle empty token
 	if token == "" {
 		return nil, errors.New("access denied: the token is empty")
 	}
 
-	//	Validate JWT token
+	//	Validate JWT token and check it is not revoked
 	claim, err := a.checkToken(token)
 	if err != nil {
 		return nil, errors.New("access denied: the access token is not valid")
 	}
-	return &claim.Username, nil
+	return a.checkRevocation(claim)
 }
 
 func (a *Auth) checkToken(tokenStr string) (*claims, error) {
 	c := &claims{}
-	tkn, err := jwt.ParseWithClaims(tokenStr, c, func(token *jwt.Token) (interface{}, error) {
-		return a.secretKey, nil
-	})
+	tkn, err := jwt.ParseWithClaims(tokenStr, c, a.verificationKey, a.parserOptions()...)
 	if err != nil {
 		if errors.Is(err, jwt.ErrSignatureInvalid) {
 			return nil, errors.New("invalid token")
 		}
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, errors.New("the token is expired")
+		}
 		a.logger.WithError(err).Warn("can not validate the token of the user")
 		return nil, errors.New("bad error in validating user token")
 	}
@@ -121,10 +284,41 @@ func (a *Auth) checkToken(tokenStr string) (*claims, error) {
 	return c, nil
 }
 
+// verificationKey is the key named by the token, which may be a retired one
+func (a *Auth) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := a.keys.Lookup(kid)
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+	return key.Secret, nil
+}
+
+// parserOptions require the registered claims issued by Login
+func (a *Auth) parserOptions() []jwt.ParserOption {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(a.tokens.ClockSkew),
+		jwt.WithTimeFunc(a.now),
+	}
+	if a.tokens.Issuer != "" {
+		options = append(options, jwt.WithIssuer(a.tokens.Issuer))
+	}
+	if a.tokens.Audience != "" {
+		options = append(options, jwt.WithAudience(a.tokens.Audience))
+	}
+	return options
+}
+
 func generateRandomKey() ([]byte, error) {
-	jwtKey := make([]byte, 32)
-	if _, err := rand.Read(jwtKey); err != nil {
+	return generateRandomBytes(32)
+}
+
+func generateRandomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
 		return nil, err
 	}
-	return jwtKey, nil
+	return b, nil
 }
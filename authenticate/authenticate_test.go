@@ -0,0 +1,217 @@
+package authenticate
+
+import (
+	"bookman/config"
+	"bookman/db"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testTokens = TokenConfig{
+	Issuer:            "bookman-test",
+	Audience:          "bookman-api",
+	Lifetime:          10 * time.Minute,
+	RefreshLifetime:   24 * time.Hour,
+	ClockSkew:         30 * time.Second,
+	ResetLifetime:     30 * time.Minute,
+	ChallengeLifetime: 5 * time.Minute,
+}
+
+// testClock is a clock only moving when told to
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestAuth returns an Auth on an in-memory database holding the user
+// "alice" with the password "password", and the clock it runs on
+func newTestAuth(t *testing.T, tokens TokenConfig) (*Auth, *testClock) {
+	t.Helper()
+	var cfg config.Config
+	cfg.Database.Driver = db.DriverSQLite
+	cfg.Database.Path = ":memory:"
+	cfg.Password.BcryptCost = bcrypt.MinCost
+	gdb, err := db.NewGormDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = gdb.CreateSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if err = gdb.CreateNewUser(&db.User{Username: "alice", Password: "password"}); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	keys, err := NewRandomKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := NewAuth(gdb, logger, keys, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &testClock{now: time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)}
+	auth.now = clock.Now
+	return auth, clock
+}
+
+func login(t *testing.T, auth *Auth) string {
+	t.Helper()
+	token, err := auth.Login(Credentials{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.TokenString
+}
+
+func TestLoginIssuesRegisteredClaims(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	c, err := auth.checkToken(login(t, auth))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Issuer != testTokens.Issuer {
+		t.Errorf("iss = %q, want %q", c.Issuer, testTokens.Issuer)
+	}
+	if len(c.Audience) != 1 || c.Audience[0] != testTokens.Audience {
+		t.Errorf("aud = %v, want [%s]", c.Audience, testTokens.Audience)
+	}
+	if c.Subject != "1" || c.Username != "alice" {
+		t.Errorf("sub = %q and username = %q, want 1 and alice", c.Subject, c.Username)
+	}
+	if !c.IssuedAt.Equal(clock.now) || !c.NotBefore.Equal(clock.now) {
+		t.Errorf("iat = %v and nbf = %v, want %v", c.IssuedAt, c.NotBefore, clock.now)
+	}
+	if want := clock.now.Add(testTokens.Lifetime); !c.ExpiresAt.Equal(want) {
+		t.Errorf("exp = %v, want %v", c.ExpiresAt, want)
+	}
+}
+
+func TestTokenExpiresInFollowsTheClock(t *testing.T) {
+	auth, _ := newTestAuth(t, testTokens)
+	token, err := auth.Login(Credentials{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.ExpiresIn != testTokens.Lifetime {
+		t.Errorf("expires in %v, want %v", token.ExpiresIn, testTokens.Lifetime)
+	}
+}
+
+func TestTokenExpires(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		valid   bool
+	}{
+		{"fresh", 0, true},
+		{"before expiry", testTokens.Lifetime - time.Second, true},
+		{"expired within the clock skew", testTokens.Lifetime + testTokens.ClockSkew - time.Second, true},
+		{"expired beyond the clock skew", testTokens.Lifetime + testTokens.ClockSkew + time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, clock := newTestAuth(t, testTokens)
+			token := login(t, auth)
+			clock.Advance(tt.elapsed)
+
+			_, err := auth.GetAccountByToken(token)
+			if valid := err == nil; valid != tt.valid {
+				t.Fatalf("valid = %v after %v, want %v (error %v)", valid, tt.elapsed, tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestTokenIssuedInTheFuture(t *testing.T) {
+	tests := []struct {
+		name  string
+		ahead time.Duration
+		valid bool
+	}{
+		{"within the clock skew", testTokens.ClockSkew - time.Second, true},
+		{"beyond the clock skew", testTokens.ClockSkew + time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the token is issued by a server whose clock is ahead
+			auth, clock := newTestAuth(t, testTokens)
+			clock.Advance(tt.ahead)
+			token := login(t, auth)
+			clock.Advance(-tt.ahead)
+
+			_, err := auth.GetAccountByToken(token)
+			if valid := err == nil; valid != tt.valid {
+				t.Fatalf("valid = %v for a token issued %v ahead, want %v (error %v)", valid, tt.ahead, tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestTokenOfAnotherIssuerOrAudience(t *testing.T) {
+	otherIssuer := testTokens
+	otherIssuer.Issuer = "someone-else"
+	otherAudience := testTokens
+	otherAudience.Audience = "another-api"
+
+	for name, tokens := range map[string]TokenConfig{"issuer": otherIssuer, "audience": otherAudience} {
+		t.Run(name, func(t *testing.T) {
+			auth, _ := newTestAuth(t, testTokens)
+			token := login(t, auth)
+
+			// the same keys validate the token for a different service
+			other := *auth
+			other.tokens = tokens
+			if _, err := other.GetAccountByToken(token); err == nil {
+				t.Fatalf("a token of another %s is accepted", name)
+			}
+		})
+	}
+}
+
+func TestTokenWithoutRequiredClaims(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	tests := map[string]jwt.MapClaims{
+		"legacy expired claim": {
+			"expired":  clock.now.Add(time.Hour).Unix(),
+			"username": "alice",
+		},
+		"no expiration": {
+			"iss": testTokens.Issuer, "aud": testTokens.Audience, "sub": "1",
+			"iat": clock.now.Unix(), "username": "alice",
+		},
+		"no subject": {
+			"iss": testTokens.Issuer, "aud": testTokens.Audience,
+			"iat": clock.now.Unix(), "exp": clock.now.Add(time.Hour).Unix(),
+		},
+	}
+	for name, claims := range tests {
+		t.Run(name, func(t *testing.T) {
+			key := auth.keys.Active()
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+			token.Header["kid"] = key.ID
+			signed, err := token.SignedString(key.Secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = auth.GetAccountByToken(signed); err == nil {
+				t.Fatal("the token is accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package authenticate
+
+import (
+	"bookman/config"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// minimum length of a signing secret, as recommended for HS256
+const minSecretLength = 32
+
+// a key file is read again at most this often, to pick up a rotation done by
+// another replica
+const keyFileReloadInterval = 10 * time.Second
+
+// SigningKey is a secret tokens are signed and verified with, identified by
+// the kid header of the tokens
+type SigningKey struct {
+	ID        string     `json:"kid"`
+	Secret    []byte     `json:"secret"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+// KeySet holds the active key new tokens are signed with, and the retired
+// keys which still verify the tokens signed before a rotation
+type KeySet struct {
+	mu         sync.RWMutex
+	active     string
+	keys       map[string]SigningKey
+	path       string
+	lastReload time.Time
+}
+
+type keyFile struct {
+	Active string       `json:"active"`
+	Keys   []SigningKey `json:"keys"`
+}
+
+// LoadKeySet reads the key file of the configuration, or otherwise uses the
+// configured secret. It returns nil when neither is configured.
+func LoadKeySet(cfg config.Config) (*KeySet, error) {
+	if cfg.Auth.KeyFile != "" {
+		return ReadKeyFile(cfg.Auth.KeyFile)
+	}
+	if cfg.Auth.Secret != "" {
+		return NewKeySetFromSecret([]byte(cfg.Auth.Secret))
+	}
+	return nil, nil
+}
+
+// NewKeySetFromSecret creates a key set of a single key, named after the hash
+// of the secret so every replica sharing the secret agrees on its ID
+func NewKeySetFromSecret(secret []byte) (*KeySet, error) {
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("the signing secret must be at least %d bytes long", minSecretLength)
+	}
+	hash := sha256.Sum256(secret)
+	key := SigningKey{ID: hex.EncodeToString(hash[:4]), Secret: secret, CreatedAt: time.Now().UTC()}
+	return &KeySet{
+		active: key.ID,
+		keys:   map[string]SigningKey{key.ID: key},
+	}, nil
+}
+
+// NewRandomKeySet creates a key set of a single random key
+func NewRandomKeySet() (*KeySet, error) {
+	key, err := newSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{
+		active: key.ID,
+		keys:   map[string]SigningKey{key.ID: *key},
+	}, nil
+}
+
+// ReadKeyFile reads a key set written by WriteKeyFile
+func ReadKeyFile(path string) (*KeySet, error) {
+	ks := &KeySet{path: path}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) load() error {
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		return fmt.Errorf("can not read the key file: %w", err)
+	}
+	var file keyFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("can not parse the key file: %w", err)
+	}
+
+	keys := map[string]SigningKey{}
+	for _, key := range file.Keys {
+		if len(key.Secret) < minSecretLength {
+			return fmt.Errorf("the key %s is shorter than %d bytes", key.ID, minSecretLength)
+		}
+		keys[key.ID] = key
+	}
+	if _, ok := keys[file.Active]; !ok {
+		return errors.New("the active key is missing from the key file")
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.active = file.Active
+	ks.keys = keys
+	ks.lastReload = time.Now()
+	return nil
+}
+
+// WriteKeyFile stores the key set, readable by its owner only
+func (ks *KeySet) WriteKeyFile(path string) error {
+	ks.mu.RLock()
+	file := keyFile{Active: ks.active}
+	file.Keys = ks.sortedKeys()
+	ks.mu.RUnlock()
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write a temporary file first so readers never see half a key set
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Active returns the key new tokens are signed with. A key set read from a
+// file reads it again now and then, so a replica stops signing with a key
+// another replica retired. The current keys are kept if the file can not be read.
+func (ks *KeySet) Active() SigningKey {
+	ks.mu.RLock()
+	reload := ks.path != "" && time.Since(ks.lastReload) > keyFileReloadInterval
+	ks.mu.RUnlock()
+	if reload {
+		_ = ks.load()
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[ks.active]
+}
+
+// Lookup returns the key with the given ID. A key set read from a file reads
+// it again when the key is unknown, since another replica may have rotated.
+func (ks *KeySet) Lookup(id string) (SigningKey, bool) {
+	ks.mu.RLock()
+	key, ok := ks.keys[id]
+	reload := !ok && ks.path != "" && time.Since(ks.lastReload) > keyFileReloadInterval
+	ks.mu.RUnlock()
+	if !reload {
+		return key, ok
+	}
+
+	if err := ks.load(); err != nil {
+		return SigningKey{}, false
+	}
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok = ks.keys[id]
+	return key, ok
+}
+
+// Keys returns every key ordered by creation
+func (ks *KeySet) Keys() []SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.sortedKeys()
+}
+
+// Rotate adds a new active key. The previous keys are retired but still
+// verify the tokens signed with them until they are pruned.
+func (ks *KeySet) Rotate() (SigningKey, error) {
+	key, err := newSigningKey()
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.keys == nil {
+		ks.keys = map[string]SigningKey{}
+	}
+	if previous, ok := ks.keys[ks.active]; ok {
+		now := time.Now()
+		previous.RetiredAt = &now
+		ks.keys[previous.ID] = previous
+	}
+	ks.keys[key.ID] = *key
+	ks.active = key.ID
+	return *key, nil
+}
+
+// Prune removes the keys retired for longer than the given duration, which
+// should be at least the lifetime of tokens so no valid token is invalidated
+func (ks *KeySet) Prune(retiredFor time.Duration) []SigningKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	var pruned []SigningKey
+	for id, key := range ks.keys {
+		if key.RetiredAt != nil && time.Since(*key.RetiredAt) > retiredFor {
+			pruned = append(pruned, key)
+			delete(ks.keys, id)
+		}
+	}
+	return pruned
+}
+
+// sortedKeys expects the lock to be held
+func (ks *KeySet) sortedKeys() []SigningKey {
+	keys := make([]SigningKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+func newSigningKey() (*SigningKey, error) {
+	secret, err := generateRandomKey()
+	if err != nil {
+		return nil, err
+	}
+	id, err := generateRandomBytes(8)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		ID:        hex.EncodeToString(id),
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,74 @@
+package authenticate
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestActiveKeyFollowsTheKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	ks, err := NewRandomKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ks.WriteKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	replica, err := ReadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another replica rotates
+	rotated, err := ks.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ks.WriteKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if replica.Active().ID == rotated.ID {
+		t.Fatal("the key file was read again before the reload interval")
+	}
+
+	replica.lastReload = time.Now().Add(-keyFileReloadInterval - time.Second)
+	if replica.Active().ID != rotated.ID {
+		t.Fatal("the active key does not follow the rotation")
+	}
+}
+
+func TestRetiredKeysVerifyUntilPruned(t *testing.T) {
+	auth, _ := newTestAuth(t, testTokens)
+	token := login(t, auth)
+	retired := auth.keys.Active().ID
+
+	rotated, err := auth.keys.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.ID == retired {
+		t.Fatal("the rotation kept the active key")
+	}
+	if _, err = auth.GetAccountByToken(token); err != nil {
+		t.Fatalf("a token of the retired key is rejected: %v", err)
+	}
+	if pruned := auth.keys.Prune(time.Hour); len(pruned) != 0 {
+		t.Fatalf("%d keys retired just now are pruned", len(pruned))
+	}
+	if _, err = auth.GetAccountByToken(token); err != nil {
+		t.Fatalf("a token of the retired key is rejected: %v", err)
+	}
+
+	// the key has been retired for longer than the tokens live
+	key := auth.keys.keys[retired]
+	longAgo := time.Now().Add(-2 * time.Hour)
+	key.RetiredAt = &longAgo
+	auth.keys.keys[retired] = key
+	if pruned := auth.keys.Prune(time.Hour); len(pruned) != 1 || pruned[0].ID != retired {
+		t.Fatalf("pruned %v, want the retired key only", pruned)
+	}
+	if _, err = auth.GetAccountByToken(token); err == nil {
+		t.Fatal("a token of a pruned key is accepted")
+	}
+}
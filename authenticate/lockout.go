@@ -0,0 +1,256 @@
+package authenticate
+
+import (
+	"bookman/config"
+	"bookman/db"
+	"errors"
+	"sort"
+	"time"
+)
+
+// LoginLimits slow down and lock out password guessing. Failed logins are
+// counted per username and per IP address, the zero value limits nothing.
+type LoginLimits struct {
+	// MaxFailures of a username within FailureWindow lock the username for
+	// Lockout after the last failure
+	MaxFailures int
+	// MaxFailuresPerIP of an IP address within FailureWindow, whatever the
+	// usernames, lock the address out the same way
+	MaxFailuresPerIP int
+	FailureWindow    time.Duration
+	Lockout          time.Duration
+	// DelayBase is how long a username waits after its first failure before
+	// it may try again. The wait doubles with every failure up to DelayMax,
+	// and stays at DelayBase without a DelayMax.
+	DelayBase time.Duration
+	DelayMax  time.Duration
+}
+
+// NewLoginLimits reads the limits of the configuration
+func NewLoginLimits(cfg config.Config) (LoginLimits, error) {
+	limits := LoginLimits{
+		MaxFailures:      cfg.Login.MaxFailures,
+		MaxFailuresPerIP: cfg.Login.MaxFailuresPerIP,
+		FailureWindow:    cfg.Login.FailureWindow,
+		Lockout:          cfg.Login.Lockout,
+		DelayBase:        cfg.Login.DelayBase,
+		DelayMax:         cfg.Login.DelayMax,
+	}
+	if limits.MaxFailures < 0 || limits.MaxFailuresPerIP < 0 {
+		return LoginLimits{}, errors.New("the maximum numbers of failed logins can not be negative")
+	}
+	if limits.enabled() && (limits.FailureWindow <= 0 || limits.Lockout <= 0) {
+		return LoginLimits{}, errors.New("the failure window and the lockout of logins must be positive")
+	}
+	if limits.DelayBase < 0 || limits.DelayMax < 0 {
+		return LoginLimits{}, errors.New("the delays of logins can not be negative")
+	}
+	if limits.DelayBase > 0 && limits.DelayMax < limits.DelayBase {
+		return LoginLimits{}, errors.New("the longest delay of logins must be at least the first delay")
+	}
+	return limits, nil
+}
+
+func (l LoginLimits) enabled() bool {
+	return l.MaxFailures > 0 || l.MaxFailuresPerIP > 0 || l.DelayBase > 0
+}
+
+// memory is how long a failure may still block logins
+func (l LoginLimits) memory() time.Duration {
+	blocked := l.Lockout
+	if l.DelayMax > blocked {
+		blocked = l.DelayMax
+	}
+	return l.FailureWindow + blocked
+}
+
+// delay is the wait after the nth failure of a username
+func (l LoginLimits) delay(n int) time.Duration {
+	d := l.DelayBase
+	for i := 1; i < n && d < l.DelayMax; i++ {
+		// doubling past the maximum could overflow
+		if d > l.DelayMax/2 {
+			d = l.DelayMax
+			break
+		}
+		d *= 2
+	}
+	if d > l.DelayMax && l.DelayMax > 0 {
+		d = l.DelayMax
+	}
+	return d
+}
+
+// blockedUntil returns until when the failures, oldest first, block logins
+// and how many of them count. max is the number of failures locking out, and
+// progressive adds the growing delay between the failures before.
+func (l LoginLimits) blockedUntil(failures []time.Time, max int, progressive bool) (time.Time, int) {
+	if len(failures) == 0 {
+		return time.Time{}, 0
+	}
+	last := failures[len(failures)-1]
+	n := 0
+	for _, t := range failures {
+		if !t.Before(last.Add(-l.FailureWindow)) {
+			n++
+		}
+	}
+	if max > 0 && n >= max {
+		return last.Add(l.Lockout), n
+	}
+	if progressive && l.DelayBase > 0 {
+		return last.Add(l.delay(n)), n
+	}
+	return time.Time{}, n
+}
+
+// LimitLogins applies the limits to the logins from now on
+func (a *Auth) LimitLogins(limits LoginLimits) {
+	a.limits = limits
+}
+
+var ErrInvalidCredentials = errors.New("the username or the password is wrong")
+
+// LockoutError is returned by Login while too many failures block the
+// username or the IP address. Until is when the next attempt is allowed,
+// RetryAfter how long that is from the attempt.
+type LockoutError struct {
+	Until      time.Time
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return "too many failed logins, try again later"
+}
+
+// Lockout is a username or an IP address blocked by its failed logins. Only
+// one of Username and IP is set.
+type Lockout struct {
+	Username string
+	IP       string
+	Failures int
+	Until    time.Time
+}
+
+// attempt is a login attempt reserved by beginAttempt. It counts as a failure
+// unless attemptSucceeded or endAttempt removes it.
+type attempt struct {
+	cred Credentials
+	id   uint
+}
+
+// beginAttempt reserves an attempt of the credentials before the password is
+// checked, and refuses it while the attempts before it block the username or
+// the IP address. Parallel guesses thus see each other and can not all pass.
+func (a *Auth) beginAttempt(cred Credentials, now time.Time) (*attempt, error) {
+	if !a.limits.enabled() {
+		return &attempt{cred: cred}, nil
+	}
+	since := now.Add(-a.limits.memory())
+	reserved := db.LoginAttempt{CreatedAt: now, Username: cred.Username, IP: cred.IP}
+	if err := a.db.ReserveLoginAttempt(&reserved, since); err != nil {
+		return nil, err
+	}
+	at := &attempt{cred: cred, id: reserved.ID}
+
+	attempts, err := a.db.GetLoginAttempts(cred.Username, cred.IP, since)
+	if err != nil {
+		a.endAttempt(at)
+		return nil, err
+	}
+	var byUsername, byIP []time.Time
+	for _, f := range attempts {
+		// only the attempts made before this one judge it
+		if f.ID >= reserved.ID {
+			continue
+		}
+		if f.Username == cred.Username {
+			byUsername = append(byUsername, f.CreatedAt)
+		}
+		if cred.IP != "" && f.IP == cred.IP {
+			byIP = append(byIP, f.CreatedAt)
+		}
+	}
+	until, _ := a.limits.blockedUntil(byUsername, a.limits.MaxFailures, true)
+	if ipUntil, _ := a.limits.blockedUntil(byIP, a.limits.MaxFailuresPerIP, false); ipUntil.After(until) {
+		until = ipUntil
+	}
+	if until.After(now) {
+		a.endAttempt(at)
+		return nil, &LockoutError{Until: until, RetryAfter: until.Sub(now)}
+	}
+	return at, nil
+}
+
+// attemptSucceeded forgets the failures of the username. Those of the IP
+// address stay, an address guessing many usernames may know one password.
+func (a *Auth) attemptSucceeded(at *attempt) {
+	if at.id == 0 {
+		return
+	}
+	if err := a.db.ClearLoginFailures(at.cred.Username, ""); err != nil {
+		a.logger.WithError(err).Warn("can not clear the failed logins of ", at.cred.Username)
+	}
+}
+
+// endAttempt removes an attempt which neither failed nor succeeded, such as
+// one refused by a lockout or stopped by an error of the database
+func (a *Auth) endAttempt(at *attempt) {
+	if at.id == 0 {
+		return
+	}
+	if err := a.db.DeleteLoginAttempt(at.id); err != nil {
+		a.logger.WithError(err).Warn("can not remove the login attempt of ", at.cred.Username)
+	}
+}
+
+// Lockouts lists the usernames and the IP addresses blocked now
+func (a *Auth) Lockouts() ([]Lockout, error) {
+	if !a.limits.enabled() {
+		return nil, nil
+	}
+	now := a.now()
+	failures, err := a.db.GetAllLoginAttempts(now.Add(-a.limits.memory()))
+	if err != nil {
+		return nil, err
+	}
+	byUsername := make(map[string][]time.Time)
+	byIP := make(map[string][]time.Time)
+	for _, f := range failures {
+		byUsername[f.Username] = append(byUsername[f.Username], f.CreatedAt)
+		if f.IP != "" {
+			byIP[f.IP] = append(byIP[f.IP], f.CreatedAt)
+		}
+	}
+
+	var lockouts []Lockout
+	for username, times := range byUsername {
+		until, n := a.limits.blockedUntil(times, a.limits.MaxFailures, true)
+		if until.After(now) {
+			lockouts = append(lockouts, Lockout{Username: username, Failures: n, Until: until})
+		}
+	}
+	for ip, times := range byIP {
+		until, n := a.limits.blockedUntil(times, a.limits.MaxFailuresPerIP, false)
+		if until.After(now) {
+			lockouts = append(lockouts, Lockout{IP: ip, Failures: n, Until: until})
+		}
+	}
+	// usernames first, then IP addresses
+	sort.Slice(lockouts, func(i, j int) bool {
+		if (lockouts[i].Username == "") != (lockouts[j].Username == "") {
+			return lockouts[i].Username != ""
+		}
+		if lockouts[i].Username != lockouts[j].Username {
+			return lockouts[i].Username < lockouts[j].Username
+		}
+		return lockouts[i].IP < lockouts[j].IP
+	})
+	return lockouts, nil
+}
+
+// ClearLockout forgets the failed logins of the username and of the IP
+// address, either of which may be empty
+func (a *Auth) ClearLockout(username, ip string) error {
+	return a.db.ClearLoginFailures(username, ip)
+}
@@ -0,0 +1,198 @@
+package authenticate
+
+import (
+	"bookman/config"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+var testLimits = LoginLimits{
+	MaxFailures:      3,
+	MaxFailuresPerIP: 5,
+	FailureWindow:    15 * time.Minute,
+	Lockout:          15 * time.Minute,
+	DelayBase:        time.Second,
+	DelayMax:         time.Minute,
+}
+
+// failLogin tries a wrong password and expects it to be refused as invalid
+func failLogin(t *testing.T, auth *Auth, username, ip string) {
+	t.Helper()
+	_, err := auth.Login(Credentials{Username: username, Password: "wrong", IP: ip})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("login of %s from %s: got %v, want %v", username, ip, err, ErrInvalidCredentials)
+	}
+}
+
+// expectLockout expects a login to be refused until the given time
+func expectLockout(t *testing.T, auth *Auth, cred Credentials, until time.Time) {
+	t.Helper()
+	_, err := auth.Login(cred)
+	var lockout *LockoutError
+	if !errors.As(err, &lockout) {
+		t.Fatalf("login of %s from %s: got %v, want a lockout", cred.Username, cred.IP, err)
+	}
+	if !lockout.Until.Equal(until) {
+		t.Fatalf("locked until %v, want %v", lockout.Until, until)
+	}
+	if want := until.Sub(auth.now()); lockout.RetryAfter != want {
+		t.Fatalf("retry after %v, want %v", lockout.RetryAfter, want)
+	}
+}
+
+func TestUnknownUsernameIsAnInvalidCredential(t *testing.T) {
+	auth, _ := newTestAuth(t, testTokens)
+
+	_, err := auth.Login(Credentials{Username: "nobody", Password: "password"})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidCredentials)
+	}
+	_, err = auth.Login(Credentials{Username: "alice", Password: "wrong"})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestLoginFailuresDelayAndLockTheUsername(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	auth.LimitLogins(testLimits)
+	right := Credentials{Username: "alice", Password: "password", IP: "192.0.2.1"}
+
+	failLogin(t, auth, "alice", "192.0.2.1")
+	expectLockout(t, auth, right, clock.now.Add(time.Second))
+
+	clock.Advance(time.Second)
+	failLogin(t, auth, "alice", "192.0.2.2")
+	expectLockout(t, auth, right, clock.now.Add(2*time.Second))
+
+	clock.Advance(2 * time.Second)
+	failLogin(t, auth, "alice", "192.0.2.3")
+	locked := clock.now.Add(testLimits.Lockout)
+	expectLockout(t, auth, right, locked)
+
+	// even the right password is refused until the lockout ends
+	clock.Advance(testLimits.Lockout - time.Second)
+	expectLockout(t, auth, right, locked)
+	clock.Advance(time.Second)
+	if _, err := auth.Login(right); err != nil {
+		t.Fatal(err)
+	}
+
+	// the successful login forgets the failures of the username
+	failLogin(t, auth, "alice", "192.0.2.1")
+	expectLockout(t, auth, right, clock.now.Add(time.Second))
+}
+
+func TestLoginFailuresOfAnIPAddress(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	auth.LimitLogins(LoginLimits{
+		MaxFailuresPerIP: 3,
+		FailureWindow:    time.Minute,
+		Lockout:          10 * time.Minute,
+	})
+
+	for _, username := range []string{"bob", "carol", "dave"} {
+		failLogin(t, auth, username, "198.51.100.7")
+		clock.Advance(10 * time.Second)
+	}
+	until := clock.now.Add(10*time.Minute - 10*time.Second)
+	expectLockout(t, auth, Credentials{Username: "alice", Password: "password", IP: "198.51.100.7"}, until)
+
+	// other addresses may still log in
+	if _, err := auth.Login(Credentials{Username: "alice", Password: "password", IP: "198.51.100.8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lockouts, err := auth.Lockouts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lockouts) != 1 || lockouts[0].IP != "198.51.100.7" || lockouts[0].Failures != 3 || !lockouts[0].Until.Equal(until) {
+		t.Fatalf("unexpected lockouts %+v", lockouts)
+	}
+
+	if err = auth.ClearLockout("", "198.51.100.7"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Login(Credentials{Username: "alice", Password: "password", IP: "198.51.100.7"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginFailuresOutsideTheWindow(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	auth.LimitLogins(LoginLimits{MaxFailures: 2, FailureWindow: time.Minute, Lockout: time.Hour})
+
+	failLogin(t, auth, "alice", "")
+	clock.Advance(2 * time.Minute)
+	failLogin(t, auth, "alice", "")
+	if _, err := auth.Login(Credentials{Username: "alice", Password: "password"}); err != nil {
+		t.Fatalf("failures further apart than the window lock out: %v", err)
+	}
+}
+
+func TestParallelGuessesCountAgainstEachOther(t *testing.T) {
+	auth, _ := newTestAuth(t, testTokens)
+	auth.LimitLogins(testLimits)
+
+	// every guess is reserved before its password is checked, so only the
+	// first one is checked and the others wait for its delay
+	const guesses = 8
+	errs := make(chan error, guesses)
+	for i := 0; i < guesses; i++ {
+		go func() {
+			_, err := auth.Login(Credentials{Username: "alice", Password: "wrong", IP: "192.0.2.1"})
+			errs <- err
+		}()
+	}
+	checked := 0
+	for i := 0; i < guesses; i++ {
+		err := <-errs
+		var lockout *LockoutError
+		switch {
+		case errors.Is(err, ErrInvalidCredentials):
+			checked++
+		case !errors.As(err, &lockout):
+			t.Fatalf("got %v, want an invalid credential or a lockout", err)
+		}
+	}
+	if checked != 1 {
+		t.Fatalf("%d parallel guesses were checked, want 1", checked)
+	}
+}
+
+func TestLoginDelayIsBounded(t *testing.T) {
+	tests := []struct {
+		limits LoginLimits
+		n      int
+		want   time.Duration
+	}{
+		{testLimits, 1, time.Second},
+		{testLimits, 4, 8 * time.Second},
+		{testLimits, 7, time.Minute},
+		{testLimits, 100, time.Minute},
+		{LoginLimits{DelayBase: time.Second}, 100, time.Second},
+		{LoginLimits{DelayBase: time.Second, DelayMax: time.Duration(math.MaxInt64)}, 100, time.Duration(math.MaxInt64)},
+	}
+	for _, tt := range tests {
+		if d := tt.limits.delay(tt.n); d != tt.want {
+			t.Errorf("delay of failure %d up to %v = %v, want %v", tt.n, tt.limits.DelayMax, d, tt.want)
+		}
+	}
+}
+
+func TestNewLoginLimitsNeedsALongestDelay(t *testing.T) {
+	var cfg config.Config
+	cfg.Login.FailureWindow = time.Minute
+	cfg.Login.Lockout = time.Minute
+	cfg.Login.DelayBase = time.Second
+	if _, err := NewLoginLimits(cfg); err == nil {
+		t.Fatal("a growing delay without a maximum is accepted")
+	}
+	cfg.Login.DelayMax = time.Minute
+	if _, err := NewLoginLimits(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
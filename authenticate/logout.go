@@ -0,0 +1,61 @@
+package authenticate
+
+import (
+	"bookman/db"
+	"errors"
+	"strconv"
+)
+
+var ErrTokenRevoked = errors.New("access denied: the access token is revoked")
+
+// Logout ends the session of the access token, revoking the token and the
+// refresh tokens issued with it
+func (a *Auth) Logout(token string) error {
+	claim, err := a.checkToken(token)
+	if err != nil {
+		return errors.New("access denied: the access token is not valid")
+	}
+	return a.db.RevokeRefreshTokenFamily(claim.SessionID)
+}
+
+// LogoutAll ends every session of the user of the access token
+func (a *Auth) LogoutAll(token string) error {
+	claim, err := a.checkToken(token)
+	if err != nil {
+		return errors.New("access denied: the access token is not valid")
+	}
+	userID, err := strconv.ParseUint(claim.Subject, 10, 64)
+	if err != nil {
+		return errors.New("access denied: the access token is not valid")
+	}
+	return a.db.RevokeUserSessions(uint(userID))
+}
+
+// checkRevocation rejects the tokens issued before the user logged out of
+// every session or had the role changed, and the tokens of a session which
+// ended. It returns the user of the token.
+func (a *Auth) checkRevocation(claim *claims) (*db.User, error) {
+	userID, err := strconv.ParseUint(claim.Subject, 10, 64)
+	if err != nil {
+		return nil, errors.New("access denied: the access token is not valid")
+	}
+	user, err := a.db.GetUserByID(uint(userID))
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, errors.New("access denied: the user does not exist")
+	} else if err != nil {
+		return nil, err
+	}
+	// a token carrying an outdated role is renewed by refreshing it
+	if user.TokenVersion != claim.Version || user.Role != claim.Role {
+		return nil, ErrTokenRevoked
+	}
+
+	revoked, err := a.db.IsRefreshTokenFamilyRevoked(claim.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return user, nil
+}
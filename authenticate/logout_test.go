@@ -0,0 +1,100 @@
+package authenticate
+
+import (
+	"bookman/db"
+	"errors"
+	"testing"
+)
+
+func TestLogoutEndsTheSession(t *testing.T) {
+	auth, _ := newTestAuth(t, testTokens)
+	session := loginTokens(t, auth)
+	other := loginTokens(t, auth)
+
+	if err := auth.Logout(session.TokenString); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.GetAccountByToken(session.TokenString); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("the access token of the session returns %v, want %v", err, ErrTokenRevoked)
+	}
+	if _, err := auth.Refresh(session.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("the refresh token of the session returns %v, want %v", err, ErrInvalidRefreshToken)
+	}
+
+	// the other session goes on
+	if _, err := auth.GetAccountByToken(other.TokenString); err != nil {
+		t.Fatalf("the access token of another session is revoked: %v", err)
+	}
+	if _, err := auth.Refresh(other.RefreshToken); err != nil {
+		t.Fatalf("the refresh token of another session is revoked: %v", err)
+	}
+}
+
+func TestLogoutAllEndsEverySession(t *testing.T) {
+	auth, _ := newTestAuth(t, testTokens)
+	first := loginTokens(t, auth)
+	second := loginTokens(t, auth)
+
+	if err := auth.LogoutAll(first.TokenString); err != nil {
+		t.Fatal(err)
+	}
+	for _, session := range []*Token{first, second} {
+		if _, err := auth.GetAccountByToken(session.TokenString); !errors.Is(err, ErrTokenRevoked) {
+			t.Fatalf("an access token returns %v, want %v", err, ErrTokenRevoked)
+		}
+		if _, err := auth.Refresh(session.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Fatalf("a refresh token returns %v, want %v", err, ErrInvalidRefreshToken)
+		}
+	}
+
+	// logging in again starts a valid session
+	if _, err := auth.GetAccountByToken(loginTokens(t, auth).TokenString); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevocationSurvivesARestart(t *testing.T) {
+	auth, _ := newTestAuth(t, testTokens)
+	session := loginTokens(t, auth)
+	if err := auth.Logout(session.TokenString); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new instance sharing the database and the keys
+	restarted, err := NewAuth(auth.db, auth.logger, auth.keys, testTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.now = auth.now
+	if _, err = restarted.GetAccountByToken(session.TokenString); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("the revoked token returns %v after a restart, want %v", err, ErrTokenRevoked)
+	}
+}
+
+func TestRoleChangeOutdatesTheTokens(t *testing.T) {
+	auth, _ := newTestAuth(t, testTokens)
+	session := loginTokens(t, auth)
+	account, err := auth.GetAccountByToken(session.TokenString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Role != db.RoleMember {
+		t.Fatalf("a new user has the role %q, want %q", account.Role, db.RoleMember)
+	}
+
+	if err = auth.db.SetUserRole(account.ID, db.RoleLibrarian); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.GetAccountByToken(session.TokenString); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("a token carrying the previous role returns %v, want %v", err, ErrTokenRevoked)
+	}
+
+	// refreshing issues a token carrying the new role
+	refreshed, err := auth.Refresh(session.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account, err = auth.GetAccountByToken(refreshed.TokenString); err != nil || account.Role != db.RoleLibrarian {
+		t.Fatalf("the refreshed token has the account %v (error %v), want the role %q", account, err, db.RoleLibrarian)
+	}
+}
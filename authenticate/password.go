@@ -0,0 +1,117 @@
+package authenticate
+
+import (
+	"bookman/config"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+//go:embed common_passwords.txt
+var defaultCommonPasswords string
+
+// maxPasswordBytes is the most bcrypt hashes, the rest of a longer password
+// would be ignored
+const maxPasswordBytes = 72
+
+// PasswordPolicy decides whether a new password is strong enough
+type PasswordPolicy struct {
+	minLength  int
+	minClasses int
+	common     map[string]struct{}
+}
+
+// defaultPolicy is checked through a nil policy, with the default lengths of
+// the configuration and the common passwords shipped with bookman
+var defaultPolicy = func() *PasswordPolicy {
+	var cfg config.Config
+	cfg.Password.MinLength = 10
+	cfg.Password.MinCharacterClasses = 3
+	policy, err := NewPasswordPolicy(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}()
+
+// NewPasswordPolicy returns the policy configured by cfg, refusing the
+// common passwords of the configured file or of the list shipped with bookman
+func NewPasswordPolicy(cfg config.Config) (*PasswordPolicy, error) {
+	if cfg.Password.MinCharacterClasses > 4 {
+		return nil, errors.New("a password can mix at most 4 character classes")
+	}
+	list := defaultCommonPasswords
+	if cfg.Password.CommonPasswordsFile != "" {
+		data, err := os.ReadFile(cfg.Password.CommonPasswordsFile)
+		if err != nil {
+			return nil, err
+		}
+		list = string(data)
+	}
+
+	policy := &PasswordPolicy{
+		minLength:  cfg.Password.MinLength,
+		minClasses: cfg.Password.MinCharacterClasses,
+		common:     map[string]struct{}{},
+	}
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy.common[strings.ToLower(line)] = struct{}{}
+	}
+	return policy, nil
+}
+
+// Check returns why the password of the user is not allowed, or nil. A nil
+// policy applies the default one rather than allowing any password.
+func (p *PasswordPolicy) Check(password, username string) error {
+	if p == nil {
+		p = defaultPolicy
+	}
+	if len([]rune(password)) < p.minLength {
+		return fmt.Errorf("the password must be at least %d characters long", p.minLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("the password must be at most %d bytes long", maxPasswordBytes)
+	}
+	if characterClasses(password) < p.minClasses {
+		return fmt.Errorf("the password must mix at least %d of lower case letters, upper case letters, digits and symbols", p.minClasses)
+	}
+	if _, ok := p.common[strings.ToLower(password)]; ok {
+		return errors.New("the password is too common")
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("the password must not contain the username")
+	}
+	return nil
+}
+
+// characterClasses counts which of lower case letters, upper case letters,
+// digits and other characters the password has
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	count := 0
+	for _, has := range []bool{lower, upper, digit, other} {
+		if has {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,203 @@
+package authenticate
+
+import (
+	"bookman/config"
+	"bookman/db"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidResetCode = errors.New("the password reset code is not valid")
+
+// ResetLimits throttle the requests for reset codes, counted per username
+// and per IP address within Window. The zero value limits nothing.
+type ResetLimits struct {
+	MaxPerUsername int
+	MaxPerIP       int
+	Window         time.Duration
+}
+
+// NewResetLimits reads the limits of the configuration
+func NewResetLimits(cfg config.Config) (ResetLimits, error) {
+	limits := ResetLimits{
+		MaxPerUsername: cfg.Auth.PasswordResetMaxPerUsername,
+		MaxPerIP:       cfg.Auth.PasswordResetMaxPerIP,
+		Window:         cfg.Auth.PasswordResetWindow,
+	}
+	if limits.MaxPerUsername < 0 || limits.MaxPerIP < 0 {
+		return ResetLimits{}, errors.New("the maximum numbers of password reset requests can not be negative")
+	}
+	if (limits.MaxPerUsername > 0 || limits.MaxPerIP > 0) && limits.Window <= 0 {
+		return ResetLimits{}, errors.New("the window of password reset requests must be positive")
+	}
+	return limits, nil
+}
+
+// LimitResetRequests applies the limits to the requests for reset codes from
+// now on
+func (a *Auth) LimitResetRequests(limits ResetLimits) {
+	a.resetLimits = limits
+}
+
+// ResetThrottledError is returned by ThrottlePasswordReset while the username
+// or the IP address asked for too many codes. Until is when the next request
+// is allowed, RetryAfter how long that is from the request.
+type ResetThrottledError struct {
+	Until      time.Time
+	RetryAfter time.Duration
+}
+
+func (e *ResetThrottledError) Error() string {
+	return "too many password reset requests, try again later"
+}
+
+// ThrottlePasswordReset counts a request for a reset code of the username
+// from the IP address, whether the user exists or not, and refuses it while
+// the requests before it reach the limits
+func (a *Auth) ThrottlePasswordReset(username, ip string) error {
+	l := a.resetLimits
+	if l.MaxPerUsername <= 0 && l.MaxPerIP <= 0 {
+		return nil
+	}
+	now := a.now()
+	since := now.Add(-l.Window)
+	request := db.PasswordResetRequest{CreatedAt: now, Username: username, IP: ip}
+	if err := a.db.ReservePasswordResetRequest(&request, since); err != nil {
+		return err
+	}
+	requests, err := a.db.GetPasswordResetRequests(username, ip, since)
+	if err != nil {
+		return err
+	}
+
+	// the oldest requests counting against the limits free a slot first
+	var byUsername, byIP []time.Time
+	for _, r := range requests {
+		if r.ID >= request.ID {
+			continue
+		}
+		if r.Username == username {
+			byUsername = append(byUsername, r.CreatedAt)
+		}
+		if ip != "" && r.IP == ip {
+			byIP = append(byIP, r.CreatedAt)
+		}
+	}
+	var until time.Time
+	if l.MaxPerUsername > 0 && len(byUsername) >= l.MaxPerUsername {
+		until = byUsername[len(byUsername)-l.MaxPerUsername].Add(l.Window)
+	}
+	if l.MaxPerIP > 0 && len(byIP) >= l.MaxPerIP {
+		if ipUntil := byIP[len(byIP)-l.MaxPerIP].Add(l.Window); ipUntil.After(until) {
+			until = ipUntil
+		}
+	}
+	if !until.After(now) {
+		return nil
+	}
+	if err = a.db.DeletePasswordResetRequest(request.ID); err != nil {
+		a.logger.WithError(err).Warn("can not remove a refused password reset request of ", username)
+	}
+	return &ResetThrottledError{Until: until, RetryAfter: until.Sub(now)}
+}
+
+// ChangePassword sets the new password of the user once the old one is
+// confirmed. Every session of the user ends, and new tokens are returned so
+// the client changing the password stays logged in. A wrong old password
+// counts as a failed login of the user from the IP address.
+func (a *Auth) ChangePassword(user *db.User, oldPassword, newPassword, ip string) (*Token, error) {
+	at, err := a.beginAttempt(Credentials{Username: user.Username, IP: ip}, a.now())
+	if err != nil {
+		return nil, err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(oldPassword))
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	// only a login forgives the earlier failures
+	a.endAttempt(at)
+	if err = a.db.ChangeUserPassword(user.ID, newPassword); err != nil {
+		return nil, err
+	}
+	return a.loginAgain(user.ID)
+}
+
+// RequestPasswordReset issues a reset code for the user, which has to be
+// delivered to the user out of band. The code replaces the codes issued
+// before.
+func (a *Auth) RequestPasswordReset(username string) (*db.User, string, error) {
+	// nobody may take over the books of deleted users
+	if username == db.GhostUsername {
+		return nil, "", db.ErrNotFound
+	}
+	user, err := a.db.GetUserByUsername(username)
+	if err != nil {
+		return nil, "", err
+	}
+
+	b, err := generateRandomBytes(32)
+	if err != nil {
+		return nil, "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+	err = a.db.CreatePasswordReset(&db.PasswordReset{
+		UserID:    user.ID,
+		CodeHash:  hashSecret(code),
+		ExpiresAt: a.now().Add(a.tokens.ResetLifetime),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return user, code, nil
+}
+
+// ResetPassword sets the new password of the user with a reset code issued
+// for the user. The code can be used once, and every session of the user ends.
+func (a *Auth) ResetPassword(username, code, newPassword string) error {
+	if code == "" {
+		return ErrInvalidResetCode
+	}
+	reset, err := a.db.GetPasswordResetByHash(hashSecret(code))
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrInvalidResetCode
+	} else if err != nil {
+		return err
+	}
+	if reset.UsedAt != nil || !a.now().Before(reset.ExpiresAt) {
+		return ErrInvalidResetCode
+	}
+
+	// the code must have been issued for the given user
+	user, err := a.db.GetUserByID(reset.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrInvalidResetCode
+	} else if err != nil {
+		return err
+	}
+	if user.Username != username {
+		return ErrInvalidResetCode
+	}
+
+	err = a.db.CompletePasswordReset(reset, newPassword)
+	if errors.Is(err, db.ErrPasswordResetUsed) {
+		return ErrInvalidResetCode
+	}
+	return err
+}
+
+// loginAgain starts a new session of the user, whose previous sessions ended
+func (a *Auth) loginAgain(userID uint) (*Token, error) {
+	user, err := a.db.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	familyID, err := generateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return a.issueTokens(user, hex.EncodeToString(familyID))
+}
@@ -0,0 +1,59 @@
+package authenticate
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResetCodeExpires(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		valid   bool
+	}{
+		{"fresh", 0, true},
+		{"before expiry", testTokens.ResetLifetime - time.Second, true},
+		{"expired", testTokens.ResetLifetime, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, clock := newTestAuth(t, testTokens)
+			_, code, err := auth.RequestPasswordReset("alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(tt.elapsed)
+
+			err = auth.ResetPassword("alice", code, "Correct-Horse-7")
+			if valid := err == nil; valid != tt.valid {
+				t.Fatalf("valid = %v after %v, want %v (error %v)", valid, tt.elapsed, tt.valid, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidResetCode) {
+				t.Fatalf("got the error %v, want %v", err, ErrInvalidResetCode)
+			}
+		})
+	}
+}
+
+func TestResetPasswordEndsSessions(t *testing.T) {
+	auth, _ := newTestAuth(t, testTokens)
+	token := login(t, auth)
+	_, code, err := auth.RequestPasswordReset("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.ResetPassword("alice", code, "Correct-Horse-7"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = auth.GetAccountByToken(token); err == nil {
+		t.Fatal("a token issued before the reset is accepted")
+	}
+	if _, err = auth.Login(Credentials{Username: "alice", Password: "password"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("login with the old password: got %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err = auth.Login(Credentials{Username: "alice", Password: "Correct-Horse-7"}); err != nil {
+		t.Fatal(err)
+	}
+}
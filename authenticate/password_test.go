@@ -0,0 +1,120 @@
+package authenticate
+
+import (
+	"bookman/config"
+	"bookman/db"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	var cfg config.Config
+	cfg.Password.MinLength = 10
+	cfg.Password.MinCharacterClasses = 3
+	policy, err := NewPasswordPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"Short-1", false},
+		{"onlylowercaseletters", false},
+		{"lowercaseanddigits123", false},
+		{"Correct-Horse-7", true},
+		{"Password123", false},
+		{"PASSWORD123", false},
+		{"Alice-Is-Here-7", false},
+		{strings.Repeat("Ab1-", 19), false},
+		{"Ünïcödé-Pässwörd-7", true},
+	}
+	for _, tt := range tests {
+		err := policy.Check(tt.password, "alice")
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("valid = %v for %q, want %v (error %v)", valid, tt.password, tt.valid, err)
+		}
+		// a nil policy applies the default one
+		var none *PasswordPolicy
+		if err = none.Check(tt.password, "alice"); (err == nil) != tt.valid {
+			t.Errorf("the nil policy gives %v for %q, want valid = %v", err, tt.password, tt.valid)
+		}
+	}
+}
+
+func TestPasswordPolicyCommonPasswordsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "common.txt")
+	if err := os.WriteFile(file, []byte("# ours\nBook-Keeper-42\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var cfg config.Config
+	cfg.Password.MinLength = 10
+	cfg.Password.CommonPasswordsFile = file
+	policy, err := NewPasswordPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = policy.Check("book-keeper-42", "alice"); err == nil {
+		t.Error("a password of the configured file is accepted")
+	}
+	if err = policy.Check("Password123", "alice"); err != nil {
+		t.Errorf("a password only in the shipped list is refused: %v", err)
+	}
+}
+
+func TestLoginRehashesOutdatedPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bookman.db")
+	open := func(cost int) *db.GormDB {
+		var cfg config.Config
+		cfg.Database.Driver = db.DriverSQLite
+		cfg.Database.Path = path
+		cfg.Password.BcryptCost = cost
+		gdb, err := db.NewGormDB(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gdb
+	}
+
+	// the user signed up while the cost was lower
+	old := open(bcrypt.MinCost)
+	if err := old.CreateSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.CreateNewUser(&db.User{Username: "alice", Password: "password"}); err != nil {
+		t.Fatal(err)
+	}
+
+	current := open(bcrypt.MinCost + 1)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	keys, err := NewRandomKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := NewAuth(current, logger, keys, testTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = auth.Login(Credentials{Username: "alice", Password: "password"}); err != nil {
+			t.Fatal(err)
+		}
+		user, err := current.GetUserByUsername("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cost, _ := bcrypt.Cost([]byte(user.Password)); cost != bcrypt.MinCost+1 {
+			t.Fatalf("the password is hashed with cost %d after login %d, want %d", cost, i+1, bcrypt.MinCost+1)
+		}
+	}
+}
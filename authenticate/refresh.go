@@ -0,0 +1,110 @@
+package authenticate
+
+import (
+	"bookman/db"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("the refresh token is not valid")
+	ErrRefreshTokenReused  = errors.New("the refresh token is already used")
+)
+
+// Refresh exchanges a refresh token for a new pair of tokens. A refresh token
+// is single use, presenting it again means it leaked, so every token of its
+// family is revoked and the login has to be repeated.
+func (a *Auth) Refresh(refreshToken string) (*Token, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	stored, err := a.db.GetRefreshTokenByHash(hashSecret(refreshToken))
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrInvalidRefreshToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	if stored.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if stored.UsedAt != nil {
+		return nil, a.revokeReusedFamily(stored)
+	}
+	if !a.now().Before(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := a.db.GetUserByID(stored.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrInvalidRefreshToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Reserve the token before issuing the new pair, a concurrent exchange of
+	// the same token is a reuse as well
+	next, secret, err := a.nextRefreshToken(stored.UserID, stored.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	err = a.db.RotateRefreshToken(stored, next)
+	if errors.Is(err, db.ErrRefreshTokenUsed) {
+		return nil, a.revokeReusedFamily(stored)
+	} else if err != nil {
+		return nil, err
+	}
+
+	token, err := a.issueAccessToken(user, stored.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	token.RefreshToken = secret
+	return token, nil
+}
+
+func (a *Auth) revokeReusedFamily(reused *db.RefreshToken) error {
+	a.logger.WithField("user_id", reused.UserID).Warn("a used refresh token is presented again, revoking its family")
+	if err := a.db.RevokeRefreshTokenFamily(reused.FamilyID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// newRefreshToken stores a new refresh token of the family and returns it
+func (a *Auth) newRefreshToken(userID uint, familyID string) (string, error) {
+	token, secret, err := a.nextRefreshToken(userID, familyID)
+	if err != nil {
+		return "", err
+	}
+	if err = a.db.CreateRefreshToken(token); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// nextRefreshToken generates a refresh token of the family without storing it
+func (a *Auth) nextRefreshToken(userID uint, familyID string) (*db.RefreshToken, string, error) {
+	b, err := generateRandomBytes(32)
+	if err != nil {
+		return nil, "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return &db.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashSecret(secret),
+		ExpiresAt: a.now().Add(a.tokens.RefreshLifetime),
+	}, secret, nil
+}
+
+// hashSecret hashes a random secret, such as a refresh token, for storage.
+// The secrets are random, so a fast hash is enough to keep a database leak
+// from revealing them.
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
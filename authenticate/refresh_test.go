@@ -0,0 +1,80 @@
+package authenticate
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func loginTokens(t *testing.T, auth *Auth) *Token {
+	t.Helper()
+	token, err := auth.Login(Credentials{Username: "alice", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRefreshRotatesTheTokens(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	first := loginTokens(t, auth)
+	clock.Advance(time.Minute)
+
+	second, err := auth.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.TokenString == first.TokenString {
+		t.Fatal("refreshing does not issue new tokens")
+	}
+	account, err := auth.GetAccountByToken(second.TokenString)
+	if err != nil || account.Username != "alice" {
+		t.Fatalf("the refreshed access token belongs to %v (error %v), want alice", account, err)
+	}
+
+	// the new refresh token is usable in turn
+	if _, err = auth.Refresh(second.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesTheFamily(t *testing.T) {
+	auth, _ := newTestAuth(t, testTokens)
+	first := loginTokens(t, auth)
+	other := loginTokens(t, auth)
+
+	second, err := auth.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.Refresh(first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reusing a refresh token returns %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if _, err = auth.Refresh(second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("refreshing after a reuse returns %v, want %v", err, ErrInvalidRefreshToken)
+	}
+
+	// the tokens of another login are left alone
+	if _, err = auth.Refresh(other.RefreshToken); err != nil {
+		t.Fatalf("the family of another login is revoked: %v", err)
+	}
+}
+
+func TestRefreshTokenExpires(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	token := loginTokens(t, auth)
+	clock.Advance(testTokens.RefreshLifetime)
+
+	if _, err := auth.Refresh(token.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("refreshing with an expired token returns %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+func TestRefreshWithAnUnknownToken(t *testing.T) {
+	auth, _ := newTestAuth(t, testTokens)
+	for _, token := range []string{"", "not-a-refresh-token", loginTokens(t, auth).TokenString} {
+		if _, err := auth.Refresh(token); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Fatalf("refreshing with %q returns %v, want %v", token, err, ErrInvalidRefreshToken)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package authenticate
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Time-based one-time passwords as in RFC 6238, with the parameters every
+// authenticator app supports: HMAC-SHA1, 30 second steps and 6 digits.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is how many steps a code may be late or early, for the clock
+	// of the device and the time it takes to type the code
+	totpSkew = 1
+)
+
+// totpEncoding is how secrets are shown to users, base32 without padding
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpStep is the number of the time step the time falls in
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode is the code of the secret for the time step, as in RFC 4226
+func totpCode(secret []byte, step int64, digits int) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation to 31 bits
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
+
+// matchTOTP returns the step within the skew of now whose code is the given
+// one, and false when none is
+func matchTOTP(secret []byte, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step, totpDigits)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// otpauthURI is the key URI authenticator apps read, usually from a QR code
+func otpauthURI(issuer, username, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
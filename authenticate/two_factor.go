@@ -0,0 +1,277 @@
+package authenticate
+
+import (
+	"bookman/db"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets when enabling
+// two-factor authentication
+const recoveryCodeCount = 10
+
+// challengePurpose tells challenge tokens apart from access tokens
+const challengePurpose = "login_2fa"
+
+var (
+	ErrInvalidChallenge     = errors.New("the challenge token is not valid")
+	ErrInvalidTwoFactorCode = errors.New("the two-factor code is not valid")
+	ErrTwoFactorNotEnrolled = errors.New("no two-factor secret is enrolled")
+	ErrTwoFactorDisabled    = errors.New("two-factor authentication is not enabled")
+)
+
+// SecondFactorRequiredError is returned by Login when the password of a user
+// with two-factor authentication is correct. The challenge is completed with
+// a code by LoginSecondFactor before it expires.
+type SecondFactorRequiredError struct {
+	Challenge string
+	ExpiresAt time.Time
+	ExpiresIn time.Duration
+}
+
+func (e *SecondFactorRequiredError) Error() string {
+	return "a second factor is required to log in"
+}
+
+// challengeClaims are the claims of a challenge token. It has neither the
+// username nor the session of an access token, so it is never accepted as
+// one.
+type challengeClaims struct {
+	jwt.RegisteredClaims
+	Purpose string `json:"purpose"`
+	// Version is the token version of the user when the password was given
+	Version uint `json:"ver"`
+}
+
+func (c *challengeClaims) Validate() error {
+	if c.Purpose != challengePurpose {
+		return errors.New("the token is not a challenge")
+	}
+	if c.ExpiresAt == nil || c.Subject == "" || c.ID == "" {
+		return errors.New("the challenge has no expiration, subject or ID")
+	}
+	return nil
+}
+
+// EnrollTwoFactor creates a new secret for the user and returns it along
+// with the otpauth URI authenticator apps read. The secret protects logins
+// once a code of it is confirmed by ConfirmTwoFactor.
+func (a *Auth) EnrollTwoFactor(user *db.User) (string, string, error) {
+	b, err := generateRandomBytes(20)
+	if err != nil {
+		return "", "", err
+	}
+	secret := totpEncoding.EncodeToString(b)
+	if err = a.db.SaveTwoFactorSecret(user.ID, secret); err != nil {
+		return "", "", err
+	}
+	return secret, otpauthURI(a.tokens.Issuer, user.Username, secret), nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the code shows the
+// user set up the enrolled secret. The recovery codes returned are not stored
+// in the clear, so they can only be shown this once. A wrong code counts as
+// a failed login of the user from the IP address.
+func (a *Auth) ConfirmTwoFactor(user *db.User, code, ip string) ([]string, error) {
+	twoFactor, err := a.db.GetTwoFactor(user.ID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrTwoFactorNotEnrolled
+	} else if err != nil {
+		return nil, err
+	}
+	if twoFactor.ConfirmedAt != nil {
+		return nil, db.ErrTwoFactorEnabled
+	}
+	secret, err := totpEncoding.DecodeString(twoFactor.Secret)
+	if err != nil {
+		return nil, err
+	}
+	now := a.now()
+	at, err := a.beginAttempt(Credentials{Username: user.Username, IP: ip}, now)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := matchTOTP(secret, strings.TrimSpace(code), now)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	// only a login forgives the earlier failures
+	a.endAttempt(at)
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b, err := generateRandomBytes(10)
+		if err != nil {
+			return nil, err
+		}
+		// 16 characters in groups of 4, easier to copy down
+		c := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = c[0:4] + "-" + c[4:8] + "-" + c[8:12] + "-" + c[12:16]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	if err = a.db.ConfirmTwoFactor(user.ID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off once the user gives
+// the password and a code, or a recovery code, again. A wrong password or
+// code counts as a failed login of the user from the IP address.
+func (a *Auth) DisableTwoFactor(user *db.User, password, code, ip string) error {
+	now := a.now()
+	at, err := a.beginAttempt(Credentials{Username: user.Username, IP: ip}, now)
+	if err != nil {
+		return err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if err != nil {
+		return ErrWrongPassword
+	}
+	err = a.checkSecondFactor(user.ID, code, now)
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		return err
+	}
+	// only a login forgives the earlier failures
+	a.endAttempt(at)
+	if err != nil {
+		return err
+	}
+	return a.db.DeleteTwoFactor(user.ID)
+}
+
+// LoginSecondFactor completes the login of a challenge with a code, or a
+// recovery code. Wrong codes count as failed logins of the user, and a
+// challenge completes one login only.
+func (a *Auth) LoginSecondFactor(challenge, code, ip string) (*Token, error) {
+	c := &challengeClaims{}
+	_, err := jwt.ParseWithClaims(challenge, c, a.verificationKey, a.parserOptions()...)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	userID, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	user, err := a.db.GetUserByID(uint(userID))
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, ErrInvalidChallenge
+	} else if err != nil {
+		return nil, err
+	}
+	// the sessions of the user ended since the password was given
+	if user.TokenVersion != c.Version {
+		return nil, ErrInvalidChallenge
+	}
+
+	now := a.now()
+	at, err := a.beginAttempt(Credentials{Username: user.Username, IP: ip}, now)
+	if err != nil {
+		return nil, err
+	}
+	err = a.checkSecondFactor(user.ID, code, now)
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		return nil, err
+	}
+	if errors.Is(err, ErrTwoFactorDisabled) {
+		a.endAttempt(at)
+		return nil, ErrInvalidChallenge
+	}
+	if err != nil {
+		a.endAttempt(at)
+		return nil, err
+	}
+
+	// a challenge completes one login only
+	err = a.db.SpendChallenge(c.ID, c.ExpiresAt.Time, now)
+	if err != nil {
+		a.endAttempt(at)
+		if errors.Is(err, db.ErrChallengeSpent) {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+	a.attemptSucceeded(at)
+
+	// Start a new family of refresh tokens for this login
+	familyID, err := generateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return a.issueTokens(user, hex.EncodeToString(familyID))
+}
+
+// issueChallenge returns the challenge the user completes with a code
+func (a *Auth) issueChallenge(user *db.User) error {
+	now := a.now()
+	expiresAt := now.Add(a.tokens.ChallengeLifetime)
+	tokenID, err := generateRandomBytes(16)
+	if err != nil {
+		return err
+	}
+	tokenJWT := jwt.NewWithClaims(jwt.SigningMethodHS256, &challengeClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(tokenID),
+			Issuer:    a.tokens.Issuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  jwt.ClaimStrings{a.tokens.Audience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Purpose: challengePurpose,
+		Version: user.TokenVersion,
+	})
+	key := a.keys.Active()
+	tokenJWT.Header["kid"] = key.ID
+	challenge, err := tokenJWT.SignedString(key.Secret)
+	if err != nil {
+		return err
+	}
+	return &SecondFactorRequiredError{Challenge: challenge, ExpiresAt: expiresAt, ExpiresIn: expiresAt.Sub(now)}
+}
+
+// checkSecondFactor accepts a code of the enabled secret of the user, which
+// is not used yet, or one of its unused recovery codes
+func (a *Auth) checkSecondFactor(userID uint, code string, now time.Time) error {
+	twoFactor, err := a.db.GetTwoFactor(userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrTwoFactorDisabled
+	} else if err != nil {
+		return err
+	}
+	if twoFactor.ConfirmedAt == nil {
+		return ErrTwoFactorDisabled
+	}
+	secret, err := totpEncoding.DecodeString(twoFactor.Secret)
+	if err != nil {
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := matchTOTP(secret, code, now); ok {
+		err = a.db.UseTwoFactorStep(userID, step)
+		if errors.Is(err, db.ErrTwoFactorCodeUsed) {
+			return ErrInvalidTwoFactorCode
+		}
+		return err
+	}
+	err = a.db.UseRecoveryCode(userID, hashRecoveryCode(code))
+	if errors.Is(err, db.ErrNotFound) {
+		return ErrInvalidTwoFactorCode
+	}
+	return err
+}
+
+// hashRecoveryCode hashes a recovery code for storage, ignoring the case and
+// the dashes and spaces a user may type
+func hashRecoveryCode(code string) string {
+	return hashSecret(strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code)))
+}
@@ -0,0 +1,219 @@
+package authenticate
+
+import (
+	"bookman/db"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCodesOfRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		if code := totpCode(secret, totpStep(time.Unix(tt.unix, 0)), 8); code != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestOtpauthURI(t *testing.T) {
+	uri := otpauthURI("bookman", "alice smith", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/bookman:alice%20smith?algorithm=SHA1&digits=6&issuer=bookman&period=30&secret=JBSWY3DPEHPK3PXP"
+	if uri != want {
+		t.Errorf("got %s, want %s", uri, want)
+	}
+}
+
+// enableTwoFactor enrolls and confirms a second factor of alice, and returns
+// its secret and the recovery codes
+func enableTwoFactor(t *testing.T, auth *Auth, clock *testClock) (*db.User, []byte, []string) {
+	t.Helper()
+	user, err := auth.db.GetUserByUsername("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, uri, err := auth.EnrollTwoFactor(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(uri, "secret="+encoded) {
+		t.Fatalf("the URI %s does not hold the secret", uri)
+	}
+	secret, err := totpEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a code of the secret which is too late
+	late := totpCode(secret, totpStep(clock.now)+10, totpDigits)
+	if _, err = auth.ConfirmTwoFactor(user, late, ""); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("confirming a wrong code: got %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+	codes, err := auth.ConfirmTwoFactor(user, totpCode(secret, totpStep(clock.now), totpDigits), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	return user, secret, codes
+}
+
+// challenge logs alice in with her password and returns the challenge
+func challenge(t *testing.T, auth *Auth) string {
+	t.Helper()
+	_, err := auth.Login(Credentials{Username: "alice", Password: "password"})
+	var required *SecondFactorRequiredError
+	if !errors.As(err, &required) {
+		t.Fatalf("got %v, want a second factor to be required", err)
+	}
+	if required.ExpiresIn != testTokens.ChallengeLifetime {
+		t.Fatalf("the challenge expires in %v, want %v", required.ExpiresIn, testTokens.ChallengeLifetime)
+	}
+	return required.Challenge
+}
+
+func TestLoginWithASecondFactor(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	access := login(t, auth)
+	_, secret, _ := enableTwoFactor(t, auth, clock)
+
+	c := challenge(t, auth)
+	if _, err := auth.GetAccountByToken(c); err == nil {
+		t.Fatal("a challenge is accepted as an access token")
+	}
+	if _, err := auth.LoginSecondFactor(access, "123456", ""); !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("an access token is accepted as a challenge: %v", err)
+	}
+	if _, err := auth.LoginSecondFactor(c, "12345", ""); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+
+	// the code of the step used to confirm is used already
+	clock.Advance(totpPeriod * time.Second)
+	code := totpCode(secret, totpStep(clock.now), totpDigits)
+	token, err := auth.LoginSecondFactor(c, code, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.GetAccountByToken(token.TokenString); err != nil {
+		t.Fatal(err)
+	}
+
+	// a code is accepted once
+	if _, err = auth.LoginSecondFactor(challenge(t, auth), code, ""); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("reusing a code: got %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+
+	// the challenge expires
+	c = challenge(t, auth)
+	clock.Advance(testTokens.ChallengeLifetime + testTokens.ClockSkew + time.Second)
+	code = totpCode(secret, totpStep(clock.now), totpDigits)
+	if _, err = auth.LoginSecondFactor(c, code, ""); !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("an expired challenge: got %v, want %v", err, ErrInvalidChallenge)
+	}
+}
+
+func TestLoginWithARecoveryCode(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	_, _, codes := enableTwoFactor(t, auth, clock)
+
+	if _, err := auth.LoginSecondFactor(challenge(t, auth), strings.ToUpper(codes[0]), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.LoginSecondFactor(challenge(t, auth), codes[0], ""); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("reusing a recovery code: got %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+	if _, err := auth.LoginSecondFactor(challenge(t, auth), codes[1], ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWrongSecondFactorsLockOut(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	auth.LimitLogins(LoginLimits{MaxFailures: 3, FailureWindow: time.Minute, Lockout: time.Hour})
+	// the wrong code given while enabling the second factor fails once
+	_, secret, _ := enableTwoFactor(t, auth, clock)
+
+	c := challenge(t, auth)
+	for i := 0; i < 2; i++ {
+		if _, err := auth.LoginSecondFactor(c, "wrong", ""); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("got %v, want %v", err, ErrInvalidTwoFactorCode)
+		}
+	}
+	clock.Advance(totpPeriod * time.Second)
+	var lockout *LockoutError
+	if _, err := auth.LoginSecondFactor(c, totpCode(secret, totpStep(clock.now), totpDigits), ""); !errors.As(err, &lockout) {
+		t.Fatalf("got %v, want a lockout", err)
+	}
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	user, secret, codes := enableTwoFactor(t, auth, clock)
+
+	if err := auth.DisableTwoFactor(user, "wrong", codes[0], ""); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("got %v, want %v", err, ErrWrongPassword)
+	}
+	if err := auth.DisableTwoFactor(user, "password", "wrong", ""); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+	clock.Advance(totpPeriod * time.Second)
+	if err := auth.DisableTwoFactor(user, "password", totpCode(secret, totpStep(clock.now), totpDigits), ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.DisableTwoFactor(user, "password", codes[0], ""); !errors.Is(err, ErrTwoFactorDisabled) {
+		t.Fatalf("got %v, want %v", err, ErrTwoFactorDisabled)
+	}
+
+	// the password is enough again
+	login(t, auth)
+}
+
+func TestDisablingTwoFactorLocksOut(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	auth.LimitLogins(LoginLimits{MaxFailures: 3, FailureWindow: time.Minute, Lockout: time.Hour})
+	user, secret, _ := enableTwoFactor(t, auth, clock)
+
+	if err := auth.DisableTwoFactor(user, "wrong", "000000", ""); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("got %v, want %v", err, ErrWrongPassword)
+	}
+	if err := auth.DisableTwoFactor(user, "password", "wrong", ""); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidTwoFactorCode)
+	}
+	var lockout *LockoutError
+	err := auth.DisableTwoFactor(user, "password", totpCode(secret, totpStep(clock.now), totpDigits), "")
+	if !errors.As(err, &lockout) {
+		t.Fatalf("got %v, want a lockout", err)
+	}
+}
+
+func TestChallengeCompletesOneLogin(t *testing.T) {
+	auth, clock := newTestAuth(t, testTokens)
+	_, secret, _ := enableTwoFactor(t, auth, clock)
+
+	c := challenge(t, auth)
+	clock.Advance(totpPeriod * time.Second)
+	if _, err := auth.LoginSecondFactor(c, totpCode(secret, totpStep(clock.now), totpDigits), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// a fresh code does not let the challenge mint another session
+	clock.Advance(totpPeriod * time.Second)
+	code := totpCode(secret, totpStep(clock.now), totpDigits)
+	if _, err := auth.LoginSecondFactor(c, code, ""); !errors.Is(err, ErrInvalidChallenge) {
+		t.Fatalf("replaying the challenge: got %v, want %v", err, ErrInvalidChallenge)
+	}
+}
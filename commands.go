@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bookman/authenticate"
+	"bookman/config"
+	"bookman/db"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage:
+  bookman                          run the http server
+  bookman migrate up               apply every pending migration
+  bookman migrate down             revert the last applied migration
+  bookman migrate status           list migrations and whether they are applied
+  bookman keys rotate              add a new signing key to AUTH_KEY_FILE and make it active
+  bookman keys list                list the signing keys of AUTH_KEY_FILE
+  bookman keys prune [duration]    remove the keys retired for longer than duration, 24h by default
+  bookman users set-role USER ROLE give the user one of the roles admin, librarian, member or readonly`
+
+// runCommand executes the command given on the command line instead of the server
+func runCommand(cfg config.Config, args []string) error {
+	switch args[0] {
+	case "migrate":
+		gormDB, err := db.NewGormDB(cfg)
+		if err != nil {
+			return err
+		}
+		return runMigrateCommand(gormDB, args[1:])
+	case "keys":
+		return runKeysCommand(cfg, args[1:])
+	case "users":
+		gormDB, err := db.NewGormDB(cfg)
+		if err != nil {
+			return err
+		}
+		return runUsersCommand(gormDB, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+func runMigrateCommand(gormDB *db.GormDB, args []string) error {
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := gormDB.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("the database is already up to date")
+		}
+	case "down":
+		reverted, err := gormDB.MigrateDown()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %04d_%s\n", reverted.Version, reverted.Name)
+	case "status":
+		status, err := gormDB.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range status {
+			state, appliedAt := "pending", "-"
+			if s.Applied {
+				state, appliedAt = "applied", s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], usage)
+	}
+	return nil
+}
+
+func runKeysCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	if cfg.Auth.KeyFile == "" {
+		return errors.New("AUTH_KEY_FILE is not set")
+	}
+
+	switch args[0] {
+	case "rotate":
+		keys, err := authenticate.ReadKeyFile(cfg.Auth.KeyFile)
+		if errors.Is(err, os.ErrNotExist) {
+			// start a new key file, which keeps the tokens signed with the
+			// configured secret valid until it is pruned
+			keys, err = &authenticate.KeySet{}, nil
+			if cfg.Auth.Secret != "" {
+				keys, err = authenticate.NewKeySetFromSecret([]byte(cfg.Auth.Secret))
+			}
+		}
+		if err != nil {
+			return err
+		}
+		key, err := keys.Rotate()
+		if err != nil {
+			return err
+		}
+		if err = keys.WriteKeyFile(cfg.Auth.KeyFile); err != nil {
+			return err
+		}
+		fmt.Printf("key %s is now active\n", key.ID)
+	case "list":
+		keys, err := authenticate.ReadKeyFile(cfg.Auth.KeyFile)
+		if err != nil {
+			return err
+		}
+		active := keys.Active().ID
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KID\tSTATUS\tCREATED AT\tRETIRED AT")
+		for _, key := range keys.Keys() {
+			state, retiredAt := "retired", "-"
+			if key.ID == active {
+				state = "active"
+			}
+			if key.RetiredAt != nil {
+				retiredAt = key.RetiredAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key.ID, state, key.CreatedAt.Format(time.RFC3339), retiredAt)
+		}
+		return tw.Flush()
+	case "prune":
+		retiredFor := 24 * time.Hour
+		if len(args) > 1 {
+			d, err := time.ParseDuration(args[1])
+			if err != nil {
+				return err
+			}
+			retiredFor = d
+		}
+		keys, err := authenticate.ReadKeyFile(cfg.Auth.KeyFile)
+		if err != nil {
+			return err
+		}
+		pruned := keys.Prune(retiredFor)
+		if err = keys.WriteKeyFile(cfg.Auth.KeyFile); err != nil {
+			return err
+		}
+		for _, key := range pruned {
+			fmt.Printf("removed key %s\n", key.ID)
+		}
+		if len(pruned) == 0 {
+			fmt.Println("there is no key to remove")
+		}
+	default:
+		return fmt.Errorf("unknown keys command %q\n%s", args[0], usage)
+	}
+	return nil
+}
+
+func runUsersCommand(gormDB *db.GormDB, args []string) error {
+	if len(args) != 3 || args[0] != "set-role" {
+		return errors.New(usage)
+	}
+
+	user, err := gormDB.GetUserByUsername(args[1])
+	if err != nil {
+		return fmt.Errorf("can not find the user %q: %w", args[1], err)
+	}
+	if err = gormDB.SetUserRole(user.ID, args[2]); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", user.Username, args[2])
+	return nil
+}
@@ -1,11 +1,103 @@
 package config
 
+import "time"
+
 type Config struct {
 	Database struct {
+		// Driver selects the database backend, either "postgres" or "sqlite"
+		Driver string `env:"DATABASE_DRIVER" env-default:"postgres"`
+		// Path is the SQLite database file, ":memory:" keeps the database in memory
+		Path     string `env:"DATABASE_PATH" env-default:"book_manager.db"`
 		Host     string `env:"DATABASE_HOST" env-default:"localhost"`
 		Port     int    `env:"DATABASE_PORT" env-default:"5432"`
 		Name     string `env:"DATABASE_NAME" env-default:"book_manager"`
 		Username string `env:"DATABASE_USERNAME" env-default:"admin"`
 		Password string `env:"DATABASE_PASSWORD" env-default:"admin"`
 	}
+	Auth struct {
+		// KeyFile holds the signing keys, it is written by "bookman keys rotate"
+		KeyFile string `env:"AUTH_KEY_FILE"`
+		// Secret is a single signing key used when there is no key file
+		Secret string `env:"AUTH_SECRET"`
+		// Issuer and Audience are issued in and required from every token
+		Issuer   string `env:"AUTH_ISSUER" env-default:"bookman"`
+		Audience string `env:"AUTH_AUDIENCE" env-default:"bookman"`
+		// AccessTokenLifetime is how long an access token stays valid
+		AccessTokenLifetime time.Duration `env:"AUTH_ACCESS_TOKEN_LIFETIME" env-default:"10m"`
+		// RefreshTokenLifetime is how long a refresh token stays valid, every
+		// refresh issues a new one
+		RefreshTokenLifetime time.Duration `env:"AUTH_REFRESH_TOKEN_LIFETIME" env-default:"720h"`
+		// ClockSkew tolerates clocks of servers being slightly apart when
+		// validating the time claims of a token
+		ClockSkew time.Duration `env:"AUTH_CLOCK_SKEW" env-default:"30s"`
+		// PasswordResetLifetime is how long a password reset code stays valid
+		PasswordResetLifetime time.Duration `env:"AUTH_PASSWORD_RESET_LIFETIME" env-default:"30m"`
+		// PasswordResetMaxPerUsername and PasswordResetMaxPerIP are how many
+		// reset codes a username and an IP address may ask for within
+		// PasswordResetWindow, 0 turns the limit off
+		PasswordResetMaxPerUsername int           `env:"AUTH_PASSWORD_RESET_MAX_PER_USERNAME" env-default:"3"`
+		PasswordResetMaxPerIP       int           `env:"AUTH_PASSWORD_RESET_MAX_PER_IP" env-default:"10"`
+		PasswordResetWindow         time.Duration `env:"AUTH_PASSWORD_RESET_WINDOW" env-default:"1h"`
+		// TwoFactorChallengeLifetime is how long a user has to give the
+		// second factor after the password
+		TwoFactorChallengeLifetime time.Duration `env:"AUTH_2FA_CHALLENGE_LIFETIME" env-default:"5m"`
+	}
+	Password struct {
+		// MinLength is the least number of characters of a new password
+		MinLength int `env:"PASSWORD_MIN_LENGTH" env-default:"10"`
+		// MinCharacterClasses is how many of lower case letters, upper case
+		// letters, digits and symbols a new password must mix
+		MinCharacterClasses int `env:"PASSWORD_MIN_CHARACTER_CLASSES" env-default:"3"`
+		// CommonPasswordsFile replaces the list of common passwords shipped
+		// with bookman, one password per line
+		CommonPasswordsFile string `env:"PASSWORD_COMMON_PASSWORDS_FILE"`
+		// BcryptCost is the cost of the password hashes, the hashes made
+		// with another cost are replaced when their user logs in
+		BcryptCost int `env:"BCRYPT_COST" env-default:"12"`
+	}
+	Login struct {
+		// MaxFailures is how many failed logins of a username within
+		// FailureWindow lock the username for Lockout, 0 turns it off
+		MaxFailures int `env:"LOGIN_MAX_FAILURES" env-default:"5"`
+		// MaxFailuresPerIP is how many failed logins from an IP address,
+		// whatever the username, lock the address out, 0 turns it off
+		MaxFailuresPerIP int           `env:"LOGIN_MAX_FAILURES_PER_IP" env-default:"20"`
+		FailureWindow    time.Duration `env:"LOGIN_FAILURE_WINDOW" env-default:"15m"`
+		Lockout          time.Duration `env:"LOGIN_LOCKOUT" env-default:"15m"`
+		// DelayBase is the wait after the first failure of a username, it
+		// doubles with every further failure up to DelayMax
+		DelayBase time.Duration `env:"LOGIN_DELAY_BASE" env-default:"1s"`
+		DelayMax  time.Duration `env:"LOGIN_DELAY_MAX" env-default:"1m"`
+		// TrustForwardedFor takes the client address from the X-Forwarded-For
+		// header set by a reverse proxy, instead of the connection
+		TrustForwardedFor bool `env:"LOGIN_TRUST_FORWARDED_FOR" env-default:"false"`
+	}
+	Accounts struct {
+		// DeletedUserBooks is what happens to the books of a deleted user:
+		// "anonymize" gives them to a placeholder user, "reassign" gives them
+		// to the user ReassignBooksTo and "cascade" deletes them
+		DeletedUserBooks string `env:"ACCOUNT_DELETED_USER_BOOKS" env-default:"anonymize"`
+		// ReassignBooksTo is the username receiving the books of deleted users
+		ReassignBooksTo string `env:"ACCOUNT_REASSIGN_BOOKS_TO"`
+	}
+	Notifier struct {
+		// Sender delivers the messages to users, either "log" or "file"
+		Sender string `env:"NOTIFIER_SENDER"`
+		// AllowInsecure lets the senders meant for development run, which
+		// do not deliver the messages or store them in the clear
+		AllowInsecure bool `env:"NOTIFIER_ALLOW_INSECURE"`
+		// File is where the file sender appends the messages
+		File string `env:"NOTIFIER_FILE" env-default:"notifications.log"`
+	}
+}
+
+// Redacted returns a copy of the configuration without secrets, safe to log
+func (c Config) Redacted() Config {
+	if c.Database.Password != "" {
+		c.Database.Password = "redacted"
+	}
+	if c.Auth.Secret != "" {
+		c.Auth.Secret = "redacted"
+	}
+	return c
 }
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// What happens to the books of a deleted user
+const (
+	DeletedUserBooksAnonymize = "anonymize"
+	DeletedUserBooksReassign  = "reassign"
+	DeletedUserBooksCascade   = "cascade"
+)
+
+// GhostUsername is the placeholder user owning the books of deleted users
+// when they are anonymized. Signing up can not take it, as usernames can not
+// contain brackets.
+const GhostUsername = "[deleted]"
+
+var (
+	ErrLastAdmin       = errors.New("the last admin can not be deleted")
+	ErrHeirUndeletable = errors.New("the user receiving the books of deleted users can not be deleted")
+)
+
+// ProfileUpdate lists the fields of a user to change, the nil ones are kept
+type ProfileUpdate struct {
+	Firstname   *string
+	Lastname    *string
+	PhoneNumber *string
+}
+
+// UpdateUserProfile changes the given fields of the user. A phone number can
+// belong to one user only.
+func (gdb *GormDB) UpdateUserProfile(userID uint, update ProfileUpdate) (*User, error) {
+	var user User
+	err := gdb.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if update.Firstname != nil {
+			user.Firstname = *update.Firstname
+		}
+		if update.Lastname != nil {
+			user.Lastname = *update.Lastname
+		}
+		if update.PhoneNumber != nil {
+			if err := checkPhoneNumberFree(tx, *update.PhoneNumber, userID); err != nil {
+				return err
+			}
+			user.PhoneNumber = *update.PhoneNumber
+		}
+		return tx.Model(&user).Select("firstname", "lastname", "phone_number").Updates(&user).Error
+	})
+	// the unique index settles a phone number both users checked at once
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return nil, ErrPhoneNumberTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// checkPhoneNumberFree makes sure no user other than the given one has the
+// phone number, users may leave it empty
+func checkPhoneNumberFree(tx *gorm.DB, phoneNumber string, userID uint) error {
+	if phoneNumber == "" {
+		return nil
+	}
+	var count int64
+	err := tx.Model(&User{}).Where("phone_number = ? AND id <> ?", phoneNumber, userID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrPhoneNumberTaken
+	}
+	return nil
+}
+
+// DeleteUser removes the user for good, along with its sessions, and handles
+// the books it created as configured by ACCOUNT_DELETED_USER_BOOKS
+func (gdb *GormDB) DeleteUser(userID uint) error {
+	return gdb.db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.Role == RoleAdmin {
+			// lock the admins so two of them deleting their accounts at once
+			// can not leave nobody, SQLite runs one transaction at a time
+			admins := tx.Model(&User{}).Where("role = ?", RoleAdmin)
+			if gdb.driver() == DriverPostgres {
+				admins = admins.Clauses(clause.Locking{Strength: "UPDATE"})
+			}
+			var ids []uint
+			if err := admins.Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+			if len(ids) == 1 {
+				return ErrLastAdmin
+			}
+		}
+
+		// the deleted books are included, they still reference the user
+		books := tx.Unscoped().Model(&Book{}).Where("created_by_id = ?", userID)
+		switch gdb.cfg.Accounts.DeletedUserBooks {
+		case DeletedUserBooksCascade:
+			if err := books.Delete(&Book{}).Error; err != nil {
+				return err
+			}
+		case DeletedUserBooksReassign:
+			heir, err := bookHeir(tx, gdb.cfg.Accounts.ReassignBooksTo)
+			if err != nil {
+				return err
+			}
+			if heir.ID == userID {
+				return ErrHeirUndeletable
+			}
+			if err := books.Update("created_by_id", heir.ID).Error; err != nil {
+				return err
+			}
+		default:
+			ghost, err := ghostUser(tx)
+			if err != nil {
+				return err
+			}
+			if err := books.Update("created_by_id", ghost.ID).Error; err != nil {
+				return err
+			}
+		}
+
+		// refresh tokens and password resets are deleted along with the user
+		return tx.Unscoped().Delete(&User{}, userID).Error
+	})
+}
+
+// CheckBookHeir makes sure the user named by ACCOUNT_REASSIGN_BOOKS_TO exists
+// when the books of deleted users are reassigned
+func (gdb *GormDB) CheckBookHeir() error {
+	if gdb.cfg.Accounts.DeletedUserBooks != DeletedUserBooksReassign {
+		return nil
+	}
+	_, err := bookHeir(&gdb.db, gdb.cfg.Accounts.ReassignBooksTo)
+	return err
+}
+
+// bookHeir returns the user receiving the books of deleted users
+func bookHeir(tx *gorm.DB, username string) (*User, error) {
+	var heir User
+	if err := tx.Where("username = ?", username).First(&heir).Error; err != nil {
+		return nil, fmt.Errorf("can not find the user %q receiving the books of deleted users: %w", username, err)
+	}
+	return &heir, nil
+}
+
+// ghostUser returns the placeholder user, adding it on first use. Its
+// password is not a bcrypt hash, so nobody can log in as it.
+func ghostUser(tx *gorm.DB) (*User, error) {
+	ghost := User{Username: GhostUsername, Role: RoleReadOnly}
+	err := tx.Where("username = ?", GhostUsername).FirstOrCreate(&ghost).Error
+	if err != nil {
+		return nil, err
+	}
+	return &ghost, nil
+}
+
+// checkDeletedUserBooks makes sure the policy for the books of deleted users
+// is known and complete
+func checkDeletedUserBooks(policy, reassignTo string) error {
+	switch policy {
+	case DeletedUserBooksAnonymize, DeletedUserBooksCascade, "":
+		return nil
+	case DeletedUserBooksReassign:
+		if reassignTo == "" {
+			return errors.New("ACCOUNT_REASSIGN_BOOKS_TO must name the user receiving the books of deleted users")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown policy %q for the books of deleted users, it must be anonymize, reassign or cascade", policy)
+	}
+}
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// seedUsersBooks adds the users leaving and staying, with a book of the
+// leaving user which is deleted already and one which is not
+func seedUsersBooks(t *testing.T, gdb *GormDB) (leaving, staying *User) {
+	t.Helper()
+	leaving = &User{Username: "leaving", Password: "password"}
+	staying = &User{Username: "staying", Password: "password"}
+	for _, u := range []*User{leaving, staying} {
+		if err := gdb.CreateNewUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"kept", "deleted"} {
+		err := gdb.CreateNewBook(&Book{Name: name, CreatedByID: leaving.ID, Author: Author{FirstName: "author"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := gdb.db.Where("name = ?", "deleted").Delete(&Book{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return leaving, staying
+}
+
+// bookOwners maps the name of every book, deleted or not, to the username
+// of the user who created it
+func bookOwners(t *testing.T, gdb *GormDB) map[string]string {
+	t.Helper()
+	var books []Book
+	if err := gdb.db.Unscoped().Preload("CreatedBy").Find(&books).Error; err != nil {
+		t.Fatal(err)
+	}
+	owners := map[string]string{}
+	for _, b := range books {
+		owners[b.Name] = b.CreatedBy.Username
+	}
+	return owners
+}
+
+func TestDeleteUserBooks(t *testing.T) {
+	tests := []struct {
+		policy string
+		owner  string
+	}{
+		{DeletedUserBooksAnonymize, GhostUsername},
+		{DeletedUserBooksReassign, "staying"},
+		{DeletedUserBooksCascade, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			gdb := newTestDB(t)
+			gdb.cfg.Accounts.DeletedUserBooks = tt.policy
+			gdb.cfg.Accounts.ReassignBooksTo = "staying"
+			leaving, _ := seedUsersBooks(t, gdb)
+
+			if err := gdb.DeleteUser(leaving.ID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := gdb.GetUserByUsername("leaving"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("the deleted user is still found: %v", err)
+			}
+
+			owners := bookOwners(t, gdb)
+			if tt.owner == "" {
+				if len(owners) != 0 {
+					t.Fatalf("the books %v are kept", owners)
+				}
+				return
+			}
+			for _, name := range []string{"kept", "deleted"} {
+				if owners[name] != tt.owner {
+					t.Errorf("the book %q belongs to %q, want %q", name, owners[name], tt.owner)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteUserReceivingBooks(t *testing.T) {
+	gdb := newTestDB(t)
+	gdb.cfg.Accounts.DeletedUserBooks = DeletedUserBooksReassign
+	gdb.cfg.Accounts.ReassignBooksTo = "leaving"
+	leaving, _ := seedUsersBooks(t, gdb)
+
+	if err := gdb.DeleteUser(leaving.ID); !errors.Is(err, ErrHeirUndeletable) {
+		t.Fatalf("got %v, want %v", err, ErrHeirUndeletable)
+	}
+	if owners := bookOwners(t, gdb); owners["kept"] != "leaving" {
+		t.Fatalf("the books changed hands: %v", owners)
+	}
+}
+
+func TestCheckBookHeir(t *testing.T) {
+	gdb := newTestDB(t)
+	gdb.cfg.Accounts.ReassignBooksTo = "staying"
+	if err := gdb.CheckBookHeir(); err != nil {
+		t.Fatalf("the heir is checked without the reassign policy: %v", err)
+	}
+
+	gdb.cfg.Accounts.DeletedUserBooks = DeletedUserBooksReassign
+	if err := gdb.CheckBookHeir(); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+	seedUsersBooks(t, gdb)
+	if err := gdb.CheckBookHeir(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPhoneNumbersAreUnique(t *testing.T) {
+	gdb := newTestDB(t)
+	if err := gdb.CreateNewUser(&User{Username: "alice", Password: "password", PhoneNumber: "+123"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index refuses what the lookup of a concurrent request missed
+	err := gdb.db.Create(&User{Username: "bob", PhoneNumber: "+123"}).Error
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Fatalf("got %v, want %v", err, gorm.ErrDuplicatedKey)
+	}
+	for _, name := range []string{"carol", "dave"} {
+		if err := gdb.CreateNewUser(&User{Username: name, Password: "password"}); err != nil {
+			t.Fatalf("an empty phone number is taken: %v", err)
+		}
+	}
+}
+
+func TestMigrationClearsDuplicatePhoneNumbers(t *testing.T) {
+	gdb := newTestDB(t)
+	revertMigrations(t, gdb, 13)
+	for _, u := range []User{
+		{Username: "first", PhoneNumber: "+123"},
+		{Username: "second", PhoneNumber: "+123"},
+		{Username: "other", PhoneNumber: "+456"},
+	} {
+		if err := gdb.db.Create(&u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := gdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"first": "+123", "second": "", "other": "+456"}
+	for username, phone := range want {
+		user, err := gdb.GetUserByUsername(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.PhoneNumber != phone {
+			t.Errorf("%s has the phone number %q, want %q", username, user.PhoneNumber, phone)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type Author struct {
+	gorm.Model
+	FirstName   string `gorm:"varchar(25)"`
+	LastName    string `gorm:"varchar(25)"`
+	Birthday    string
+	Nationality string `gorm:"varchar(25)"`
+}
+
+var (
+	ErrAuthorExists = errors.New("this author is already added")
+	ErrAuthorInUse  = errors.New("the author still has books")
+)
+
+// normalizeAuthor trims the fields of an author the way they are stored and
+// looked up
+func normalizeAuthor(author *Author) {
+	author.FirstName = strings.TrimSpace(author.FirstName)
+	author.LastName = strings.TrimSpace(author.LastName)
+	author.Birthday = strings.TrimSpace(author.Birthday)
+	author.Nationality = strings.TrimSpace(author.Nationality)
+}
+
+func (gdb *GormDB) CreateNewAuthor(newAuthor *Author) error {
+	normalizeAuthor(newAuthor)
+
+	// check duplicate author, the unique index catches concurrent ones
+	if _, err := gdb.FindAuthor(*newAuthor); err == nil {
+		return ErrAuthorExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	err := gdb.db.Create(newAuthor).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrAuthorExists
+	}
+	return err
+}
+
+// FindAuthor looks up an existing author with the same name, ignoring case.
+// The birthday is only compared when it is given, so a request without a
+// birthday matches the author added with one.
+func (gdb *GormDB) FindAuthor(author Author) (*Author, error) {
+	return findAuthor(&gdb.db, author)
+}
+
+func findAuthor(tx *gorm.DB, author Author) (*Author, error) {
+	query := tx.
+		Where("LOWER(first_name) = ?", strings.ToLower(strings.TrimSpace(author.FirstName))).
+		Where("LOWER(last_name) = ?", strings.ToLower(strings.TrimSpace(author.LastName)))
+	if birthday := strings.TrimSpace(author.Birthday); birthday != "" {
+		query = query.Where("birthday = ?", birthday)
+	}
+
+	var existingAuthor Author
+	err := query.Order("id").First(&existingAuthor).Error
+	if err != nil {
+		return nil, err
+	}
+	return &existingAuthor, nil
+}
+
+// FindOrCreateAuthor returns the existing author matching the given one, or
+// adds it when there is none
+func (gdb *GormDB) FindOrCreateAuthor(author *Author) (*Author, error) {
+	return findOrCreateAuthor(&gdb.db, author)
+}
+
+// findOrCreateAuthor is FindOrCreateAuthor within the transaction tx. An
+// author added concurrently is looked up again once the insert fails.
+func findOrCreateAuthor(tx *gorm.DB, author *Author) (*Author, error) {
+	normalizeAuthor(author)
+	existingAuthor, err := findAuthor(tx, *author)
+	if err == nil {
+		return existingAuthor, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// a savepoint keeps a failed insert from aborting the transaction in
+	// postgres
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(author).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return findAuthor(tx, *author)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return author, nil
+}
+
+func (gdb *GormDB) GetAllAuthors() ([]Author, error) {
+	var allAuthors []Author
+	err := gdb.db.Order("id").Find(&allAuthors).Error
+	if err != nil {
+		return nil, err
+	}
+	return allAuthors, nil
+}
+
+func (gdb *GormDB) GetAuthorByID(authorID uint) (*Author, error) {
+	var author Author
+	err := gdb.db.Where("id = ?", authorID).First(&author).Error
+	if err != nil {
+		return nil, err
+	}
+	return &author, nil
+}
+
+func (gdb *GormDB) UpdateAuthorByID(author *Author, authorID uint) (*Author, error) {
+	existingAuthor, err := gdb.GetAuthorByID(authorID)
+	if err != nil {
+		return nil, err
+	}
+
+	//	update given data in our request body
+	normalizeAuthor(author)
+	if author.FirstName != "" {
+		existingAuthor.FirstName = author.FirstName
+	}
+	if author.LastName != "" {
+		existingAuthor.LastName = author.LastName
+	}
+	if author.Birthday != "" {
+		existingAuthor.Birthday = author.Birthday
+	}
+	if author.Nationality != "" {
+		existingAuthor.Nationality = author.Nationality
+	}
+
+	// the new name must not collide with another author
+	duplicate, err := gdb.FindAuthor(Author{
+		FirstName: existingAuthor.FirstName,
+		LastName:  existingAuthor.LastName,
+		Birthday:  existingAuthor.Birthday,
+	})
+	if err == nil && duplicate.ID != existingAuthor.ID {
+		return nil, ErrAuthorExists
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	err = gdb.db.Save(existingAuthor).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return nil, ErrAuthorExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return existingAuthor, nil
+}
+
+func (gdb *GormDB) DeleteAuthorByID(authorID uint) error {
+	if _, err := gdb.GetAuthorByID(authorID); err != nil {
+		return err
+	}
+
+	// deleting an author cascades to its books, so refuse while it has any
+	var count int64
+	if err := gdb.db.Model(&Book{}).Where("author_id = ?", authorID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAuthorInUse
+	}
+	if err := gdb.db.Model(&BookContributor{}).Where("author_id = ?", authorID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAuthorInUse
+	}
+	return gdb.db.Delete(&Author{}, authorID).Error
+}
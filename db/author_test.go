@@ -0,0 +1,39 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestAuthorsAreStoredOnce(t *testing.T) {
+	gdb := newTestDB(t)
+
+	author := Author{FirstName: " Frank ", LastName: "Herbert  ", Birthday: "1920-10-08"}
+	if err := gdb.CreateNewAuthor(&author); err != nil {
+		t.Fatal(err)
+	}
+	if author.FirstName != "Frank" || author.LastName != "Herbert" {
+		t.Fatalf("the name is stored as %q %q", author.FirstName, author.LastName)
+	}
+
+	err := gdb.CreateNewAuthor(&Author{FirstName: "frank", LastName: " HERBERT", Birthday: "1920-10-08"})
+	if !errors.Is(err, ErrAuthorExists) {
+		t.Fatalf("got %v, want %v", err, ErrAuthorExists)
+	}
+
+	// an insert racing past the lookup is stopped by the unique index
+	err = gdb.db.Create(&Author{FirstName: "FRANK", LastName: "herbert", Birthday: "1920-10-08"}).Error
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Fatalf("got %v, want %v", err, gorm.ErrDuplicatedKey)
+	}
+
+	found, err := gdb.FindOrCreateAuthor(&Author{FirstName: "Frank ", LastName: "Herbert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != author.ID {
+		t.Fatalf("found the author %d, want %d", found.ID, author.ID)
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"errors"
+
 	"gorm.io/gorm"
 )
 
@@ -12,14 +13,6 @@ type TableOfContent struct {
 	Item   string
 }
 
-type Author struct {
-	gorm.Model
-	FirstName   string `gorm:"varchar(25)"`
-	LastName    string `gorm:"varchar(25)"`
-	Birthday    string
-	Nationality string `gorm:"varchar(25)"`
-}
-
 type Book struct {
 	gorm.Model
 	Name            string `gorm:"varchar(25), unique"`
@@ -30,18 +23,56 @@ type Book struct {
 	Category        string `gorm:"varchar(20)"`
 	Volume          uint
 	PublishedAt     string
-	Summary         string           `gorm:"varchar(100)"`
-	Publisher       string           `gorm:"varchar(20)"`
-	TableOfContents []TableOfContent `gorm:"constraint:OnDelete:CASCADE"` // Cascading delete for TableOfContent
+	Summary         string            `gorm:"varchar(100)"`
+	Publisher       string            `gorm:"varchar(20)"`
+	TableOfContents []TableOfContent  `gorm:"constraint:OnDelete:CASCADE"` // Cascading delete for TableOfContent
+	Contributors    []BookContributor `gorm:"constraint:OnDelete:CASCADE"` // Authors, translators and editors
+}
+
+// Categories a book can be filed under
+var BookCategories = []string{
+	"fiction", "poetry", "drama", "biography", "history", "science",
+	"philosophy", "religion", "art", "children", "comics", "reference",
+	"textbook", "other",
 }
 
+var (
+	ErrBookExists        = errors.New("this book is already added")
+	ErrBookWithoutAuthor = errors.New("the book has no author")
+)
+
 func (gdb *GormDB) CreateNewBook(newBook *Book) error {
-	// check duplicate book
-	var count int64
-	if gdb.db.Model(&Book{}).Where("name = ?", newBook.Name).Count(&count); count > 0 {
-		return errors.New("this book is already added")
+	if err := checkContributorRoles(newBook.Contributors); err != nil {
+		return err
 	}
-	return gdb.db.Create(newBook).Error
+
+	// the authors are only added along with the book
+	return gdb.db.Transaction(func(tx *gorm.DB) error {
+		// check duplicate book
+		if err := checkBookNameFree(tx, newBook.Name, 0); err != nil {
+			return err
+		}
+
+		// reference the given author, or reuse an existing author with the same name
+		author, err := resolveAuthor(tx, newBook.AuthorID, newBook.Author)
+		if err != nil {
+			return err
+		}
+		newBook.AuthorID = author.ID
+		newBook.Author = *author
+
+		// list the primary author along with co-authors, translators and editors
+		newBook.Contributors, err = resolveContributors(tx, author, newBook.Contributors)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(newBook).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrBookExists
+		}
+		return err
+	})
 }
 
 func (gdb *GormDB) GetCreatedByUsernameByID(bookID uint) (*string, error) {
@@ -61,68 +92,168 @@ func (gdb *GormDB) DeleteBookByID(bookID uint) error {
 	return gdb.db.Delete(&Book{}, bookID).Error
 }
 
+// UpdateBookByID changes the given fields of the book. The book, its table
+// of contents and its contributors change together or not at all.
 func (gdb *GormDB) UpdateBookByID(book *Book, bookID uint) (*Book, error) {
-	//	find the book with bookID in postgres database
+	if err := checkContributorRoles(book.Contributors); err != nil {
+		return nil, err
+	}
+
 	var existingBook Book
-	err := gdb.db.First(&existingBook, bookID).Error
+	err := gdb.db.Transaction(func(tx *gorm.DB) error {
+		//	find the book with bookID in the database
+		err := tx.First(&existingBook, bookID).Error
+		if err != nil {
+			return err
+		}
+
+		//	update given data in our request body
+		if book.Name != "" && book.Name != existingBook.Name {
+			if err = checkBookNameFree(tx, book.Name, bookID); err != nil {
+				return err
+			}
+			existingBook.Name = book.Name
+		}
+		if book.Volume != 0 {
+			existingBook.Volume = book.Volume
+		}
+		if book.PublishedAt != "" {
+			existingBook.PublishedAt = book.PublishedAt
+		}
+		if book.Publisher != "" {
+			existingBook.Publisher = book.Publisher
+		}
+		if book.Summary != "" {
+			existingBook.Summary = book.Summary
+		}
+		if book.Category != "" {
+			existingBook.Category = book.Category
+		}
+		if book.TableOfContents != nil {
+			err = tx.Where("book_id = ?", bookID).Delete(&TableOfContent{}).Error
+			if err != nil {
+				return err
+			}
+			existingBook.TableOfContents = book.TableOfContents
+		}
+		// Point the book at another author instead of editing the author, which
+		// may be shared with other books
+		previousAuthorID := existingBook.AuthorID
+		if book.AuthorID != 0 || !isEmptyAuthor(book.Author) {
+			author, err := resolveAuthor(tx, book.AuthorID, book.Author)
+			if err != nil {
+				return err
+			}
+			existingBook.AuthorID = author.ID
+			existingBook.Author = *author
+		}
+
+		// replace the contributors when they are given, otherwise only swap the
+		// primary author if it has changed
+		if book.Contributors != nil || existingBook.AuthorID != previousAuthorID {
+			contributors := book.Contributors
+			if contributors == nil {
+				existingContributors, err := getContributorsByBookID(tx, bookID)
+				if err != nil {
+					return err
+				}
+				for _, c := range existingContributors {
+					if c.AuthorID != previousAuthorID || c.Role != ContributorAuthor {
+						contributors = append(contributors, c)
+					}
+				}
+			}
+
+			primary, err := resolveAuthor(tx, existingBook.AuthorID, Author{})
+			if err != nil {
+				return err
+			}
+			contributors, err = resolveContributors(tx, primary, contributors)
+			if err != nil {
+				return err
+			}
+			if err = replaceContributors(tx, bookID, contributors); err != nil {
+				return err
+			}
+		}
+
+		//	save changed data
+		return saveBook(tx, &existingBook)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return &existingBook, nil
+}
 
-	//	update given data in our request body
-	if book.Name != "" {
-		existingBook.Name = book.Name
+// ReplaceBookByID replaces every field of the book, the fields which are not
+// given are cleared. Like UpdateBookByID, nothing changes when it fails.
+func (gdb *GormDB) ReplaceBookByID(book *Book, bookID uint) (*Book, error) {
+	if err := checkContributorRoles(book.Contributors); err != nil {
+		return nil, err
 	}
-	if book.Volume != 0 {
-		existingBook.Volume = book.Volume
+	if book.AuthorID == 0 && isEmptyAuthor(book.Author) {
+		return nil, ErrBookWithoutAuthor
 	}
-	if book.PublishedAt != "" {
+
+	var existingBook Book
+	err := gdb.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.First(&existingBook, bookID).Error
+		if err != nil {
+			return err
+		}
+		if book.Name != existingBook.Name {
+			if err = checkBookNameFree(tx, book.Name, bookID); err != nil {
+				return err
+			}
+		}
+
+		existingBook.Name = book.Name
+		existingBook.Volume = book.Volume
 		existingBook.PublishedAt = book.PublishedAt
-	}
-	if book.Publisher != "" {
 		existingBook.Publisher = book.Publisher
-	}
-	if book.Summary != "" {
 		existingBook.Summary = book.Summary
-	}
-	if book.Category != "" {
 		existingBook.Category = book.Category
-	}
-	if book.TableOfContents != nil {
-		err = gdb.db.Where("book_id = ?", bookID).Delete(&TableOfContent{}).Error
+
+		err = tx.Where("book_id = ?", bookID).Delete(&TableOfContent{}).Error
 		if err != nil {
-			return nil, err
+			return err
 		}
 		existingBook.TableOfContents = book.TableOfContents
-	}
-	checkAuthor := Author{
-		FirstName:   "",
-		LastName:    "",
-		Nationality: "",
-		Birthday:    "",
-	}
-	if book.Author != checkAuthor {
-		existedAuthor, err := gdb.GetAuthorByID(existingBook.AuthorID)
+
+		author, err := resolveAuthor(tx, book.AuthorID, book.Author)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		// Update the author fields from the request body
-		existedAuthor.FirstName = book.Author.FirstName
-		existedAuthor.LastName = book.Author.LastName
-		existedAuthor.Birthday = book.Author.Birthday
-		existedAuthor.Nationality = book.Author.Nationality
-		gdb.db.Save(existedAuthor)
-	}
+		existingBook.AuthorID = author.ID
+		existingBook.Author = *author
 
-	//	save changed data
-	err = gdb.db.Save(existingBook).Error
+		contributors, err := resolveContributors(tx, author, book.Contributors)
+		if err != nil {
+			return err
+		}
+		if err = replaceContributors(tx, bookID, contributors); err != nil {
+			return err
+		}
+
+		return saveBook(tx, &existingBook)
+	})
 	if err != nil {
 		return nil, err
 	}
-
 	return &existingBook, nil
 }
 
+// saveBook stores the changed book, reporting a name taken meanwhile as
+// ErrBookExists
+func saveBook(tx *gorm.DB, book *Book) error {
+	err := tx.Save(book).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrBookExists
+	}
+	return err
+}
+
 func (gdb *GormDB) GetAllBooks() ([]Book, error) {
 	var allBooks []Book
 	err := gdb.db.Find(&allBooks).Error
@@ -134,7 +265,7 @@ func (gdb *GormDB) GetAllBooks() ([]Book, error) {
 
 func (gdb *GormDB) GetABookByID(bookId uint) (*Book, error) {
 	var book Book
-	err := gdb.db.Where("id = ?", bookId).First(&book).Error
+	err := preloadBookDetails(&gdb.db).Where("id = ?", bookId).First(&book).Error
 	if err != nil {
 		return nil, err
 	}
@@ -150,11 +281,51 @@ func (gdb *GormDB) GetContentsByBookID(bookID uint) ([]string, error) {
 	return contents, nil
 }
 
-func (gdb *GormDB) GetAuthorByID(authorID uint) (*Author, error) {
-	var author Author
-	err := gdb.db.Where("id = ?", authorID).First(&author).Error
+// resolveAuthor returns the author with the given ID, or otherwise the
+// existing author matching the given details, adding it if there is none
+func resolveAuthor(tx *gorm.DB, authorID uint, author Author) (*Author, error) {
+	if authorID != 0 {
+		var existingAuthor Author
+		if err := tx.Where("id = ?", authorID).First(&existingAuthor).Error; err != nil {
+			return nil, err
+		}
+		return &existingAuthor, nil
+	}
+	return findOrCreateAuthor(tx, &author)
+}
+
+// checkBookNameFree makes sure no other book has the name
+func checkBookNameFree(tx *gorm.DB, name string, bookID uint) error {
+	var count int64
+	err := tx.Model(&Book{}).Where("name = ? AND id <> ?", name, bookID).Count(&count).Error
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &author, nil
+	if count > 0 {
+		return ErrBookExists
+	}
+	return nil
+}
+
+func isEmptyAuthor(author Author) bool {
+	return author.FirstName == "" && author.LastName == "" &&
+		author.Birthday == "" && author.Nationality == ""
+}
+
+// preloadBookDetails loads the author, the creator, the table of contents and
+// the contributors of the queried books with one query each, however many books
+// there are
+func preloadBookDetails(query *gorm.DB) *gorm.DB {
+	return query.
+		Preload("Author").
+		Preload("CreatedBy", func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("id", "username")
+		}).
+		Preload("TableOfContents", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("id")
+		}).
+		Preload("Contributors", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("position")
+		}).
+		Preload("Contributors.Author")
 }
@@ -0,0 +1,311 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// BookFilter narrows down and orders the books returned by FindBooks, zero
+// fields are ignored
+type BookFilter struct {
+	Name            string
+	Author          string
+	Contributor     string
+	ContributorRole string
+	Category        string
+	Publisher       string
+	Volume          uint
+	PublishedFrom   string
+	PublishedTo     string
+	// Search matches the name and the summary of books
+	Search string
+	// Sort is a comma separated list of fields, a field prefixed with "-" is
+	// sorted in descending order
+	Sort string
+}
+
+// Page selects a window of a book listing, either by an offset or by the
+// cursor of the book right after or right before the window
+type Page struct {
+	Limit  int
+	Offset int
+	After  string
+	Before string
+}
+
+// BookPage is one window of a book listing
+type BookPage struct {
+	Books []Book
+	// Total is the number of books matching the filter in all pages
+	Total   int64
+	HasNext bool
+	HasPrev bool
+	// NextCursor and PrevCursor point at the last and the first book of the page
+	NextCursor string
+	PrevCursor string
+}
+
+var (
+	ErrInvalidSort   = errors.New("books can only be sorted by name, author, category, publisher, volume or published_at")
+	ErrInvalidCursor = errors.New("the cursor is not valid for this listing")
+)
+
+type sortColumn struct {
+	column string
+	value  func(book *Book) interface{}
+}
+
+// bookSortColumns maps the sortable fields to their columns and to the value
+// a book has in them, which is what cursors are made of
+var bookSortColumns = map[string]sortColumn{
+	"name": {"books.name", func(b *Book) interface{} { return b.Name }},
+	"author": {"COALESCE(authors.last_name, '')", func(b *Book) interface{} {
+		return b.Author.LastName
+	}},
+	"category":     {"books.category", func(b *Book) interface{} { return b.Category }},
+	"publisher":    {"books.publisher", func(b *Book) interface{} { return b.Publisher }},
+	"volume":       {"books.volume", func(b *Book) interface{} { return b.Volume }},
+	"published_at": {"books.published_at", func(b *Book) interface{} { return b.PublishedAt }},
+}
+
+type orderColumn struct {
+	sortColumn
+	desc bool
+}
+
+// bookCursor identifies a book within a listing ordered by Sort
+type bookCursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+	ID     uint          `json:"id"`
+}
+
+func (gdb *GormDB) FindBooks(filter BookFilter, page Page) (*BookPage, error) {
+	order, err := parseBookSort(filter.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BookPage{}
+	if err = gdb.filterBooks(filter).Count(&result.Total).Error; err != nil {
+		return nil, err
+	}
+
+	// fetch one more book than asked to know whether another page follows
+	query := preloadBookDetails(gdb.filterBooks(filter).Select("books.*")).Limit(page.Limit + 1)
+	backward := false
+	switch {
+	case page.After != "":
+		cursor, err := decodeBookCursor(page.After, filter.Sort, order)
+		if err != nil {
+			return nil, err
+		}
+		condition, args := keysetCondition(order, cursor, true)
+		query = query.Where(condition, args...)
+	case page.Before != "":
+		cursor, err := decodeBookCursor(page.Before, filter.Sort, order)
+		if err != nil {
+			return nil, err
+		}
+		condition, args := keysetCondition(order, cursor, false)
+		query = query.Where(condition, args...)
+		backward = true
+	default:
+		query = query.Offset(page.Offset)
+	}
+
+	for _, c := range order {
+		direction := " ASC"
+		if c.desc != backward {
+			direction = " DESC"
+		}
+		query = query.Order(c.column + direction)
+	}
+	// keep the order stable between requests
+	if backward {
+		query = query.Order("books.id DESC")
+	} else {
+		query = query.Order("books.id")
+	}
+
+	if err = query.Find(&result.Books).Error; err != nil {
+		return nil, err
+	}
+
+	more := len(result.Books) > page.Limit
+	if more {
+		result.Books = result.Books[:page.Limit]
+	}
+	switch {
+	case page.After != "":
+		result.HasNext, result.HasPrev = more, true
+	case page.Before != "":
+		result.HasNext, result.HasPrev = true, more
+		// the books were read backwards from the cursor
+		for i, j := 0, len(result.Books)-1; i < j; i, j = i+1, j-1 {
+			result.Books[i], result.Books[j] = result.Books[j], result.Books[i]
+		}
+	default:
+		result.HasNext, result.HasPrev = more, page.Offset > 0
+	}
+
+	if len(result.Books) > 0 {
+		result.PrevCursor = encodeBookCursor(&result.Books[0], filter.Sort, order)
+		result.NextCursor = encodeBookCursor(&result.Books[len(result.Books)-1], filter.Sort, order)
+	}
+	return result, nil
+}
+
+// filterBooks builds the query of the books matching the filter
+func (gdb *GormDB) filterBooks(filter BookFilter) *gorm.DB {
+	query := gdb.db.Model(&Book{}).
+		Joins("LEFT JOIN authors ON authors.id = books.author_id")
+
+	if filter.Name != "" {
+		query = query.Where("LOWER(books.name) LIKE ? ESCAPE '\\'", containsPattern(filter.Name))
+	}
+	if filter.Author != "" {
+		query = query.Where("LOWER(authors.first_name || ' ' || authors.last_name) LIKE ? ESCAPE '\\'", containsPattern(filter.Author))
+	}
+	if filter.Contributor != "" {
+		contributors := gdb.db.Model(&BookContributor{}).
+			Select("book_contributors.book_id").
+			Joins("JOIN authors ON authors.id = book_contributors.author_id").
+			Where("LOWER(authors.first_name || ' ' || authors.last_name) LIKE ? ESCAPE '\\'", containsPattern(filter.Contributor))
+		if filter.ContributorRole != "" {
+			contributors = contributors.Where("book_contributors.role = ?", filter.ContributorRole)
+		}
+		query = query.Where("books.id IN (?)", contributors)
+	}
+	if filter.Category != "" {
+		query = query.Where("LOWER(books.category) = ?", strings.ToLower(filter.Category))
+	}
+	if filter.Publisher != "" {
+		query = query.Where("LOWER(books.publisher) = ?", strings.ToLower(filter.Publisher))
+	}
+	if filter.Volume != 0 {
+		query = query.Where("books.volume = ?", filter.Volume)
+	}
+	if filter.PublishedFrom != "" {
+		query = query.Where("books.published_at >= ?", filter.PublishedFrom)
+	}
+	if filter.PublishedTo != "" {
+		query = query.Where("books.published_at <= ?", filter.PublishedTo)
+	}
+	if filter.Search != "" {
+		pattern := containsPattern(filter.Search)
+		query = query.Where("(LOWER(books.name) LIKE ? ESCAPE '\\' OR LOWER(books.summary) LIKE ? ESCAPE '\\')", pattern, pattern)
+	}
+	return query
+}
+
+func parseBookSort(sort string) ([]orderColumn, error) {
+	var order []orderColumn
+	if sort == "" {
+		return order, nil
+	}
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		desc := strings.HasPrefix(field, "-")
+		column, ok := bookSortColumns[strings.TrimPrefix(field, "-")]
+		if !ok {
+			return nil, ErrInvalidSort
+		}
+		order = append(order, orderColumn{sortColumn: column, desc: desc})
+	}
+	return order, nil
+}
+
+// keysetCondition matches the books ordered after the cursor, or before it
+// when forward is false
+func keysetCondition(order []orderColumn, cursor *bookCursor, forward bool) (string, []interface{}) {
+	var terms []string
+	var args []interface{}
+	for i := 0; i <= len(order); i++ {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, order[j].column+" = ?")
+			args = append(args, cursor.Values[j])
+		}
+		if i < len(order) {
+			operator := " > ?"
+			if order[i].desc == forward {
+				operator = " < ?"
+			}
+			parts = append(parts, order[i].column+operator)
+			args = append(args, cursor.Values[i])
+		} else {
+			operator := " > ?"
+			if !forward {
+				operator = " < ?"
+			}
+			parts = append(parts, "books.id"+operator)
+			args = append(args, cursor.ID)
+		}
+		terms = append(terms, "("+strings.Join(parts, " AND ")+")")
+	}
+	return "(" + strings.Join(terms, " OR ") + ")", args
+}
+
+func encodeBookCursor(book *Book, sort string, order []orderColumn) string {
+	cursor := bookCursor{Sort: sort, ID: book.ID, Values: []interface{}{}}
+	for _, c := range order {
+		cursor.Values = append(cursor.Values, c.value(book))
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeBookCursor reads a cursor, which is only valid for the sort it was
+// made for and holds a value of the type of every sorted column
+func decodeBookCursor(encoded string, sort string, order []orderColumn) (*bookCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	var cursor bookCursor
+	if err = decoder.Decode(&cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort != sort || len(cursor.Values) != len(order) {
+		return nil, ErrInvalidCursor
+	}
+	for i, c := range order {
+		if cursor.Values[i], err = cursorValue(cursor.Values[i], c.value(&Book{})); err != nil {
+			return nil, err
+		}
+	}
+	return &cursor, nil
+}
+
+// cursorValue converts a decoded cursor value to the type of the sample value
+// of its column, strings and unsigned integers being the only ones
+func cursorValue(v interface{}, sample interface{}) (interface{}, error) {
+	switch sample.(type) {
+	case string:
+		if text, ok := v.(string); ok {
+			return text, nil
+		}
+	case uint:
+		if number, ok := v.(json.Number); ok {
+			if n, err := number.Int64(); err == nil && n >= 0 {
+				return uint(n), nil
+			}
+		}
+	}
+	return nil, ErrInvalidCursor
+}
+
+// containsPattern builds a case-insensitive LIKE pattern matching any value
+// that contains the given text
+func containsPattern(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return "%" + replacer.Replace(strings.ToLower(text)) + "%"
+}
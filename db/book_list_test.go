@@ -0,0 +1,339 @@
+package db
+
+import (
+	"bookman/config"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens a migrated in-memory SQLite database
+func newTestDB(tb testing.TB) *GormDB {
+	tb.Helper()
+	var cfg config.Config
+	cfg.Database.Driver = DriverSQLite
+	cfg.Database.Path = ":memory:"
+	cfg.Password.BcryptCost = bcrypt.MinCost
+
+	gdb, err := NewGormDB(cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	gdb.db.Logger = logger.Default.LogMode(logger.Silent)
+	if err = gdb.CreateSchema(); err != nil {
+		tb.Fatal(err)
+	}
+	return gdb
+}
+
+// seedBooks adds books with an author, contents and a translator each
+func seedBooks(tb testing.TB, gdb *GormDB, count int) {
+	tb.Helper()
+	user := User{Username: "seeder", Password: "seeder"}
+	if err := gdb.CreateNewUser(&user); err != nil {
+		tb.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		err := gdb.CreateNewBook(&Book{
+			Name:        fmt.Sprintf("book %d", i),
+			CreatedByID: user.ID,
+			Author:      Author{FirstName: "author", LastName: fmt.Sprint(i % 7)},
+			Contributors: []BookContributor{{
+				Author: Author{FirstName: "translator", LastName: fmt.Sprint(i % 5)},
+				Role:   ContributorTranslator,
+			}},
+			TableOfContents: []TableOfContent{{Item: "first"}, {Item: "second"}},
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// countQueries counts the queries run against the database from now on
+func countQueries(tb testing.TB, gdb *GormDB) *int {
+	tb.Helper()
+	queries := new(int)
+	err := gdb.db.Callback().Query().After("gorm:query").Register("test:count_queries", func(*gorm.DB) {
+		*queries++
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return queries
+}
+
+func TestFindBooksQueryCountIsConstant(t *testing.T) {
+	var counts []int
+	for _, size := range []int{1, 10, 100} {
+		gdb := newTestDB(t)
+		seedBooks(t, gdb, size)
+		queries := countQueries(t, gdb)
+
+		page, err := gdb.FindBooks(BookFilter{Sort: "author"}, Page{Limit: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Books) != size {
+			t.Fatalf("got %d books, want %d", len(page.Books), size)
+		}
+		for _, book := range page.Books {
+			if book.Author.ID == 0 || len(book.TableOfContents) != 2 || len(book.Contributors) != 2 {
+				t.Fatalf("book %q is not fully loaded", book.Name)
+			}
+			if book.Contributors[1].Author.ID == 0 {
+				t.Fatalf("the contributors of book %q are not loaded", book.Name)
+			}
+		}
+		counts = append(counts, *queries)
+	}
+
+	for _, count := range counts[1:] {
+		if count != counts[0] {
+			t.Fatalf("listing books costs %v queries for 1, 10 and 100 books, want a constant count", counts)
+		}
+	}
+}
+
+func BenchmarkFindBooks(b *testing.B) {
+	for _, size := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("books=%d", size), func(b *testing.B) {
+			gdb := newTestDB(b)
+			seedBooks(b, gdb, size)
+			queries := countQueries(b, gdb)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := gdb.FindBooks(BookFilter{}, Page{Limit: size}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(*queries)/float64(b.N), "queries/op")
+		})
+	}
+}
+
+// bookNames lists the names of the books in order
+func bookNames(books []Book) []string {
+	names := []string{}
+	for _, b := range books {
+		names = append(names, b.Name)
+	}
+	return names
+}
+
+func TestFindBooksCursorPaging(t *testing.T) {
+	gdb := newTestDB(t)
+	seedBooks(t, gdb, 10)
+	filter := BookFilter{Sort: "-author,name"}
+
+	all, err := gdb.FindBooks(filter, Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bookNames(all.Books)
+
+	// walk forwards three books at a time
+	var forward []string
+	page, err := gdb.FindBooks(filter, Page{Limit: 3})
+	for ; err == nil; page, err = gdb.FindBooks(filter, Page{Limit: 3, After: page.NextCursor}) {
+		forward = append(forward, bookNames(page.Books)...)
+		if len(forward) > 3 && !page.HasPrev {
+			t.Fatal("a page after the first has no previous page")
+		}
+		if !page.HasNext {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(forward, ",") != strings.Join(want, ",") {
+		t.Fatalf("paging forwards gives %v, want %v", forward, want)
+	}
+
+	// and back from the last page
+	backward := bookNames(page.Books)
+	for page.HasPrev {
+		if page, err = gdb.FindBooks(filter, Page{Limit: 3, Before: page.PrevCursor}); err != nil {
+			t.Fatal(err)
+		}
+		if !page.HasNext {
+			t.Fatal("a page before the last has no next page")
+		}
+		backward = append(bookNames(page.Books), backward...)
+	}
+	if strings.Join(backward, ",") != strings.Join(want, ",") {
+		t.Fatalf("paging backwards gives %v, want %v", backward, want)
+	}
+}
+
+func TestFindBooksInvalidCursors(t *testing.T) {
+	gdb := newTestDB(t)
+	seedBooks(t, gdb, 3)
+	page, err := gdb.FindBooks(BookFilter{Sort: "author"}, Page{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(cursor string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+	}
+	tests := []struct {
+		name   string
+		sort   string
+		cursor string
+	}{
+		{"another sort", "name", page.NextCursor},
+		{"not base64", "", "!!"},
+		{"not JSON", "", encode("cursor")},
+		{"object ID", "", encode(`{"s":"","v":[],"id":{}}`)},
+		{"missing value", "name", encode(`{"s":"name","v":[],"id":1}`)},
+		{"object value", "name", encode(`{"s":"name","v":[{}],"id":1}`)},
+		{"array value", "name", encode(`{"s":"name","v":[[]],"id":1}`)},
+		{"null value", "name", encode(`{"s":"name","v":[null],"id":1}`)},
+		{"bool value", "name", encode(`{"s":"name","v":[true],"id":1}`)},
+		{"number for a string", "name", encode(`{"s":"name","v":[1],"id":1}`)},
+		{"string for a number", "volume", encode(`{"s":"volume","v":["1"],"id":1}`)},
+		{"negative number", "volume", encode(`{"s":"volume","v":[-1],"id":1}`)},
+		{"fraction", "volume", encode(`{"s":"volume","v":[1.5],"id":1}`)},
+		{"huge number", "volume", encode(`{"s":"volume","v":[99999999999999999999],"id":1}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, p := range []Page{{Limit: 1, After: tt.cursor}, {Limit: 1, Before: tt.cursor}} {
+				if _, err := gdb.FindBooks(BookFilter{Sort: tt.sort}, p); !errors.Is(err, ErrInvalidCursor) {
+					t.Fatalf("got %v, want %v", err, ErrInvalidCursor)
+				}
+			}
+		})
+	}
+
+	// a well-formed cursor of the volume sort is accepted
+	cursor := encode(`{"s":"volume","v":[1],"id":1}`)
+	if _, err := gdb.FindBooks(BookFilter{Sort: "volume"}, Page{Limit: 1, After: cursor}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// seedLibrary adds a few books which differ in every filtered field
+func seedLibrary(t *testing.T, gdb *GormDB) {
+	t.Helper()
+	user := User{Username: "librarian", Password: "password"}
+	if err := gdb.CreateNewUser(&user); err != nil {
+		t.Fatal(err)
+	}
+	books := []Book{
+		{
+			Name: "Dune", Category: "fiction", Publisher: "Chilton", Volume: 1, PublishedAt: "1965-08-01",
+			Author: Author{FirstName: "Frank", LastName: "Herbert"},
+			Contributors: []BookContributor{
+				{Author: Author{FirstName: "Jane", LastName: "Doe"}, Role: ContributorTranslator},
+			},
+		},
+		{
+			Name: "Dune Messiah", Category: "fiction", Publisher: "Putnam", Volume: 2, PublishedAt: "1969-10-15",
+			Author: Author{FirstName: "Frank", LastName: "Herbert"},
+			Contributors: []BookContributor{
+				{Author: Author{FirstName: "Jane", LastName: "Doe"}, Role: ContributorEditor},
+			},
+		},
+		{
+			Name: "100% Pure_Logic", Category: "science", Publisher: "Chilton", Volume: 1, PublishedAt: "1999-01-01",
+			Author: Author{FirstName: "Ada", LastName: "Lovelace"},
+		},
+		{
+			Name: "Emma", Category: "fiction", Publisher: "Murray", Volume: 1, PublishedAt: "1815-12-23",
+			Author: Author{FirstName: "Jane", LastName: "Austen"},
+		},
+	}
+	for i := range books {
+		books[i].CreatedByID = user.ID
+		if err := gdb.CreateNewBook(&books[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindBooksFilters(t *testing.T) {
+	gdb := newTestDB(t)
+	seedLibrary(t, gdb)
+
+	tests := []struct {
+		name   string
+		filter BookFilter
+		want   string
+	}{
+		{"everything", BookFilter{}, "Dune,Dune Messiah,100% Pure_Logic,Emma"},
+		{"name", BookFilter{Name: "dune"}, "Dune,Dune Messiah"},
+		{"name with a percent sign", BookFilter{Name: "100%"}, "100% Pure_Logic"},
+		{"percent sign taken literally", BookFilter{Name: "%"}, "100% Pure_Logic"},
+		{"underscore taken literally", BookFilter{Name: "e_"}, "100% Pure_Logic"},
+		{"author", BookFilter{Author: "frank herb"}, "Dune,Dune Messiah"},
+		{"contributor", BookFilter{Contributor: "jane doe"}, "Dune,Dune Messiah"},
+		{"contributor with a role", BookFilter{Contributor: "jane doe", ContributorRole: ContributorEditor}, "Dune Messiah"},
+		{"contributor as the primary author", BookFilter{Contributor: "austen", ContributorRole: ContributorAuthor}, "Emma"},
+		{"category", BookFilter{Category: "Science"}, "100% Pure_Logic"},
+		{"publisher", BookFilter{Publisher: "chilton"}, "Dune,100% Pure_Logic"},
+		{"volume", BookFilter{Volume: 2}, "Dune Messiah"},
+		{"published from", BookFilter{PublishedFrom: "1969-10-15"}, "Dune Messiah,100% Pure_Logic"},
+		{"published to", BookFilter{PublishedTo: "1965-08-01"}, "Dune,Emma"},
+		{"published between", BookFilter{PublishedFrom: "1900-01-01", PublishedTo: "1990-01-01"}, "Dune,Dune Messiah"},
+		{"combined", BookFilter{Category: "fiction", Publisher: "chilton"}, "Dune"},
+		{"no match", BookFilter{Name: "dune", Category: "science"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := gdb.FindBooks(tt.filter, Page{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(bookNames(page.Books), ","); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			if page.Total != int64(len(page.Books)) {
+				t.Fatalf("the total is %d for %d books", page.Total, len(page.Books))
+			}
+		})
+	}
+}
+
+func TestFindBooksSort(t *testing.T) {
+	gdb := newTestDB(t)
+	seedLibrary(t, gdb)
+
+	tests := []struct {
+		sort string
+		want string
+	}{
+		{"name", "100% Pure_Logic,Dune,Dune Messiah,Emma"},
+		{"-name", "Emma,Dune Messiah,Dune,100% Pure_Logic"},
+		{"published_at", "Emma,Dune,Dune Messiah,100% Pure_Logic"},
+		{"-volume,name", "Dune Messiah,100% Pure_Logic,Dune,Emma"},
+		{"author,-published_at", "Emma,Dune Messiah,Dune,100% Pure_Logic"},
+		{"category, publisher", "Dune,Emma,Dune Messiah,100% Pure_Logic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			page, err := gdb.FindBooks(BookFilter{Sort: tt.sort}, Page{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(bookNames(page.Books), ","); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, sort := range []string{"summary", "name,", "--name", "id"} {
+		if _, err := gdb.FindBooks(BookFilter{Sort: sort}, Page{Limit: 10}); !errors.Is(err, ErrInvalidSort) {
+			t.Errorf("sorting by %q: got %v, want %v", sort, err, ErrInvalidSort)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// seedTwoBooks adds the books "Dune" and "Emma", the first with a translator
+func seedTwoBooks(t *testing.T, gdb *GormDB) (dune, emma *Book) {
+	t.Helper()
+	user := User{Username: "alice", Password: "password"}
+	if err := gdb.CreateNewUser(&user); err != nil {
+		t.Fatal(err)
+	}
+	dune = &Book{
+		Name:            "Dune",
+		CreatedByID:     user.ID,
+		Author:          Author{FirstName: "Frank", LastName: "Herbert"},
+		TableOfContents: []TableOfContent{{Item: "Book One"}},
+		Contributors: []BookContributor{
+			{Author: Author{FirstName: "Jane", LastName: "Doe"}, Role: ContributorTranslator},
+		},
+	}
+	emma = &Book{Name: "Emma", CreatedByID: user.ID, Author: Author{FirstName: "Jane", LastName: "Austen"}}
+	for _, b := range []*Book{dune, emma} {
+		if err := gdb.CreateNewBook(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dune, emma
+}
+
+// expectUnchanged fails the test when the book lost its contents or
+// contributors
+func expectUnchanged(t *testing.T, gdb *GormDB, bookID uint) {
+	t.Helper()
+	book, err := gdb.GetABookByID(bookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Name != "Dune" || len(book.TableOfContents) != 1 || len(book.Contributors) != 2 {
+		t.Fatalf("the failed change is partly applied: %s with %d contents and %d contributors",
+			book.Name, len(book.TableOfContents), len(book.Contributors))
+	}
+}
+
+func TestFailedUpdateChangesNothing(t *testing.T) {
+	gdb := newTestDB(t)
+	dune, _ := seedTwoBooks(t, gdb)
+
+	// the contents are cleared before the missing contributor is found
+	_, err := gdb.UpdateBookByID(&Book{
+		TableOfContents: []TableOfContent{},
+		Contributors:    []BookContributor{{AuthorID: 999}},
+	}, dune.ID)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+	expectUnchanged(t, gdb, dune.ID)
+
+	_, err = gdb.UpdateBookByID(&Book{Name: "Emma", TableOfContents: []TableOfContent{}}, dune.ID)
+	if !errors.Is(err, ErrBookExists) {
+		t.Fatalf("got %v, want %v", err, ErrBookExists)
+	}
+	expectUnchanged(t, gdb, dune.ID)
+}
+
+func TestFailedReplaceChangesNothing(t *testing.T) {
+	gdb := newTestDB(t)
+	dune, _ := seedTwoBooks(t, gdb)
+
+	_, err := gdb.ReplaceBookByID(&Book{
+		Name:         "Dune",
+		Author:       Author{FirstName: "Frank", LastName: "Herbert"},
+		Contributors: []BookContributor{{AuthorID: 999}},
+	}, dune.ID)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+	expectUnchanged(t, gdb, dune.ID)
+
+	_, err = gdb.ReplaceBookByID(&Book{Name: "Emma", Author: Author{FirstName: "Frank", LastName: "Herbert"}}, dune.ID)
+	if !errors.Is(err, ErrBookExists) {
+		t.Fatalf("got %v, want %v", err, ErrBookExists)
+	}
+	expectUnchanged(t, gdb, dune.ID)
+}
+
+func TestFailedCreateAddsNothing(t *testing.T) {
+	gdb := newTestDB(t)
+	seedTwoBooks(t, gdb)
+	var before int64
+	if err := gdb.db.Model(&Author{}).Count(&before).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// the new author is added before the missing contributor is found
+	err := gdb.CreateNewBook(&Book{
+		Name:         "Persuasion",
+		Author:       Author{FirstName: "Anne", LastName: "Elliot"},
+		Contributors: []BookContributor{{AuthorID: 999}},
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+	var after int64
+	if err := gdb.db.Model(&Author{}).Count(&after).Error; err != nil {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Fatalf("%d authors are left behind", after-before)
+	}
+}
+
+func TestBookNamesAreUnique(t *testing.T) {
+	gdb := newTestDB(t)
+	dune, _ := seedTwoBooks(t, gdb)
+
+	// the index refuses what the lookup of a concurrent request missed
+	err := gdb.db.Create(&Book{Name: "Dune", CreatedByID: dune.CreatedByID, AuthorID: dune.AuthorID}).Error
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Fatalf("got %v, want %v", err, gorm.ErrDuplicatedKey)
+	}
+
+	// a deleted book leaves its name free
+	if err = gdb.DeleteBookByID(dune.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = gdb.CreateNewBook(&Book{Name: "Dune", CreatedByID: dune.CreatedByID, AuthorID: dune.AuthorID}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrationRenamesDuplicateBooks(t *testing.T) {
+	gdb := newTestDB(t)
+	dune, _ := seedTwoBooks(t, gdb)
+	revertMigrations(t, gdb, 14)
+	again := Book{Name: "Dune", CreatedByID: dune.CreatedByID, AuthorID: dune.AuthorID}
+	if err := gdb.db.Create(&again).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[uint]string{dune.ID: "Dune", again.ID: fmt.Sprintf("Dune (%d)", again.ID)}
+	for id, name := range want {
+		book, err := gdb.GetABookByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if book.Name != name {
+			t.Errorf("the book %d is named %q, want %q", id, book.Name, name)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Roles an author can have in a book
+const (
+	ContributorAuthor     = "author"
+	ContributorTranslator = "translator"
+	ContributorEditor     = "editor"
+)
+
+var ErrInvalidContributorRole = errors.New("the role of a contributor must be author, translator or editor")
+
+// BookContributor links an author to a book with the role the author had in it
+type BookContributor struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	BookID    uint
+	AuthorID  uint
+	Author    Author `gorm:"foreignKey:AuthorID"`
+	Role      string `gorm:"varchar(20)"`
+	Position  uint
+}
+
+func IsValidContributorRole(role string) bool {
+	switch role {
+	case ContributorAuthor, ContributorTranslator, ContributorEditor:
+		return true
+	}
+	return false
+}
+
+func (gdb *GormDB) GetContributorsByBookID(bookID uint) ([]BookContributor, error) {
+	return getContributorsByBookID(&gdb.db, bookID)
+}
+
+func getContributorsByBookID(tx *gorm.DB, bookID uint) ([]BookContributor, error) {
+	var contributors []BookContributor
+	err := tx.Preload("Author").Where("book_id = ?", bookID).Order("position").Find(&contributors).Error
+	if err != nil {
+		return nil, err
+	}
+	return contributors, nil
+}
+
+// checkContributorRoles makes sure every given role is known, an empty role
+// stands for an author
+func checkContributorRoles(contributors []BookContributor) error {
+	for _, c := range contributors {
+		if c.Role != "" && !IsValidContributorRole(c.Role) {
+			return ErrInvalidContributorRole
+		}
+	}
+	return nil
+}
+
+// resolveContributors finds or adds the author of every contributor, and
+// makes sure the primary author of the book is listed first as an author
+func resolveContributors(tx *gorm.DB, primary *Author, contributors []BookContributor) ([]BookContributor, error) {
+	resolved := []BookContributor{{AuthorID: primary.ID, Author: *primary, Role: ContributorAuthor}}
+	for _, c := range contributors {
+		if c.Role == "" {
+			c.Role = ContributorAuthor
+		}
+
+		author, err := resolveAuthor(tx, c.AuthorID, c.Author)
+		if err != nil {
+			return nil, err
+		}
+		if author.ID == primary.ID && c.Role == ContributorAuthor {
+			continue
+		}
+		resolved = append(resolved, BookContributor{AuthorID: author.ID, Author: *author, Role: c.Role})
+	}
+
+	for i := range resolved {
+		resolved[i].Position = uint(i)
+	}
+	return resolved, nil
+}
+
+// replaceContributors swaps the contributors of a book for the given ones
+func replaceContributors(tx *gorm.DB, bookID uint, contributors []BookContributor) error {
+	if err := tx.Where("book_id = ?", bookID).Delete(&BookContributor{}).Error; err != nil {
+		return err
+	}
+	for i := range contributors {
+		contributors[i].ID = 0
+		contributors[i].BookID = bookID
+	}
+	if len(contributors) == 0 {
+		return nil
+	}
+	return tx.Omit("Author").Create(&contributors).Error
+}
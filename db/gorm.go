@@ -5,37 +5,85 @@ import (
 	"fmt"
 
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 // Setup Database Connection
 
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
 type GormDB struct {
 	cfg config.Config
 	db  gorm.DB
 }
 
 func NewGormDB(cfg config.Config) (*GormDB, error) {
-	c := fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=disable",
-		cfg.Database.Host,
-		cfg.Database.Port,
-		cfg.Database.Username,
-		cfg.Database.Name,
-		cfg.Database.Password)
-
-	// Create a new database connection
-	db, err := gorm.Open(postgres.Open(c))
+	if err := checkBcryptCost(cfg.Password.BcryptCost); err != nil {
+		return nil, err
+	}
+	if err := checkDeletedUserBooks(cfg.Accounts.DeletedUserBooks, cfg.Accounts.ReassignBooksTo); err != nil {
+		return nil, err
+	}
+
+	dialector, err := newDialector(cfg)
 	if err != nil {
 		return nil, err
 	}
+
+	// Create a new database connection, reporting unique constraint
+	// violations as gorm.ErrDuplicatedKey whatever the driver
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Database.Driver == DriverSQLite {
+		// SQLite allows a single writer, and an in-memory database only lives
+		// as long as its connection, so keep exactly one open connection
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
 	return &GormDB{
 		cfg: cfg,
 		db:  *db,
 	}, nil
 }
 
+func newDialector(cfg config.Config) (gorm.Dialector, error) {
+	switch cfg.Database.Driver {
+	case DriverPostgres, "":
+		c := fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=disable",
+			cfg.Database.Host,
+			cfg.Database.Port,
+			cfg.Database.Username,
+			cfg.Database.Name,
+			cfg.Database.Password)
+		return postgres.Open(c), nil
+	case DriverSQLite:
+		// Foreign keys are disabled by default in SQLite, enable them so
+		// cascading deletes behave as they do in postgres
+		c := fmt.Sprintf("%s?_foreign_keys=on&_busy_timeout=5000", cfg.Database.Path)
+		if cfg.Database.Path == ":memory:" {
+			c = "file::memory:?_foreign_keys=on"
+		}
+		return sqlite.Open(c), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Database.Driver)
+	}
+}
+
+// CreateSchema brings the database schema up to date by applying every
+// pending migration
 func (gdb *GormDB) CreateSchema() error {
-	err := gdb.db.AutoMigrate(&User{}, &TableOfContent{}, &Author{}, &Book{})
+	_, err := gdb.MigrateUp()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,68 @@
+package db
+
+import "time"
+
+// LoginAttempt is a login in progress or a failed one, kept for a while to
+// count the failures of a username and of an IP address. An attempt is
+// stored before the password is checked, so parallel guesses count against
+// each other, and removed again when it succeeds.
+type LoginAttempt struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Username  string
+	IP        string
+}
+
+// ReserveLoginAttempt stores the attempt and drops the attempts made before
+// keepSince, which no longer count
+func (gdb *GormDB) ReserveLoginAttempt(attempt *LoginAttempt, keepSince time.Time) error {
+	if err := gdb.db.Create(attempt).Error; err != nil {
+		return err
+	}
+	return gdb.db.Where("created_at < ?", keepSince).Delete(&LoginAttempt{}).Error
+}
+
+// GetLoginAttempts returns the attempts of the username or of the IP address
+// made since the given time, oldest first
+func (gdb *GormDB) GetLoginAttempts(username, ip string, since time.Time) ([]LoginAttempt, error) {
+	var attempts []LoginAttempt
+	err := gdb.db.Where("(username = ? OR ip = ?) AND created_at >= ?", username, ip, since).
+		Order("created_at, id").
+		Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// GetAllLoginAttempts returns every attempt made since the given time, oldest
+// first
+func (gdb *GormDB) GetAllLoginAttempts(since time.Time) ([]LoginAttempt, error) {
+	var attempts []LoginAttempt
+	err := gdb.db.Where("created_at >= ?", since).Order("created_at, id").Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// DeleteLoginAttempt removes an attempt which does not count as a failure
+func (gdb *GormDB) DeleteLoginAttempt(id uint) error {
+	return gdb.db.Delete(&LoginAttempt{}, id).Error
+}
+
+// ClearLoginFailures forgets the failures of the username and those of the
+// IP address. An empty username or IP address is left alone.
+func (gdb *GormDB) ClearLoginFailures(username, ip string) error {
+	if username != "" {
+		if err := gdb.db.Where("username = ?", username).Delete(&LoginAttempt{}).Error; err != nil {
+			return err
+		}
+	}
+	if ip != "" {
+		if err := gdb.db.Where("ip = ?", ip).Delete(&LoginAttempt{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
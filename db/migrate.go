@@ -0,0 +1,245 @@
+package db
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations live in migrations/<driver>/NNNN_name.up.sql and the matching
+// NNNN_name.down.sql. Statements are separated by semicolons, so migration
+// files must not contain semicolons inside string literals or comments.
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   uint
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// schemaMigration is a row of the schema_migrations table, one per applied migration
+type schemaMigration struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+func (gdb *GormDB) driver() string {
+	if gdb.cfg.Database.Driver == "" {
+		return DriverPostgres
+	}
+	return gdb.cfg.Database.Driver
+}
+
+// loadMigrations reads the migrations of the given driver ordered by version
+func loadMigrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for driver %q", driver)
+	}
+
+	byVersion := map[uint]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		versionPart, name, found := strings.Cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s is not named NNNN_name", fileName)
+		}
+		version, err := strconv.ParseUint(versionPart, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has an invalid version", fileName)
+		}
+
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: name}
+			byVersion[uint(version)] = m
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// execScript runs every statement of a migration script in the given transaction
+func execScript(tx *gorm.DB, script string) error {
+	for _, statement := range strings.Split(script, ";") {
+		if isBlankStatement(statement) {
+			continue
+		}
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isBlankStatement reports whether a statement holds nothing but whitespace and comments
+func isBlankStatement(statement string) bool {
+	for _, line := range strings.Split(statement, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
+
+func (gdb *GormDB) appliedMigrations() (map[uint]schemaMigration, error) {
+	if err := gdb.db.AutoMigrate(&schemaMigration{}); err != nil {
+		return nil, err
+	}
+	var rows []schemaMigration
+	if err := gdb.db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := map[uint]schemaMigration{}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// MigrateUp applies every pending migration in order and returns the applied ones
+func (gdb *GormDB) MigrateUp() ([]Migration, error) {
+	migrations, err := loadMigrations(gdb.driver())
+	if err != nil {
+		return nil, err
+	}
+	applied, err := gdb.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err = gdb.db.Transaction(func(tx *gorm.DB) error {
+			if err := execScript(tx, m.Up); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the most recently applied migration
+func (gdb *GormDB) MigrateDown() (*Migration, error) {
+	migrations, err := loadMigrations(gdb.driver())
+	if err != nil {
+		return nil, err
+	}
+	applied, err := gdb.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s can not be reverted", m.Version, m.Name)
+		}
+		err = gdb.db.Transaction(func(tx *gorm.DB) error {
+			if err := execScript(tx, m.Down); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return nil, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		return &m, nil
+	}
+	return nil, errors.New("there is no applied migration to revert")
+}
+
+// MigrationStatus lists every known migration and whether it is applied
+func (gdb *GormDB) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(gdb.driver())
+	if err != nil {
+		return nil, err
+	}
+	applied, err := gdb.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, m := range migrations {
+		row, ok := applied[m.Version]
+		status = append(status, MigrationStatus{
+			Version:   m.Version,
+			Name:      m.Name,
+			Applied:   ok,
+			AppliedAt: row.AppliedAt,
+		})
+	}
+	return status, nil
+}
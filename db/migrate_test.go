@@ -0,0 +1,118 @@
+package db
+
+import (
+	"bookman/config"
+	"testing"
+
+	"gorm.io/gorm/logger"
+)
+
+// revertMigrations reverts the applied migrations down to the given version,
+// included, so a test can add the data the migrations after it fix
+func revertMigrations(tb testing.TB, gdb *GormDB, version uint) {
+	tb.Helper()
+	for {
+		m, err := gdb.MigrateDown()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if m.Version <= version {
+			return
+		}
+	}
+}
+
+// expectApplied fails the test unless exactly the first count migrations are
+// applied
+func expectApplied(t *testing.T, gdb *GormDB, count int) {
+	t.Helper()
+	status, err := gdb.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range status {
+		if s.Applied != (i < count) {
+			t.Fatalf("migration %04d_%s applied = %v, want %v", s.Version, s.Name, s.Applied, i < count)
+		}
+		if s.Applied && s.AppliedAt.IsZero() {
+			t.Fatalf("migration %04d_%s has no application time", s.Version, s.Name)
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	var cfg config.Config
+	cfg.Database.Driver = DriverSQLite
+	cfg.Database.Path = ":memory:"
+	gdb, err := NewGormDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gdb.db.Logger = logger.Default.LogMode(logger.Silent)
+
+	migrations, err := loadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectApplied(t, gdb, 0)
+
+	applied, err := gdb.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+	expectApplied(t, gdb, len(migrations))
+
+	// nothing is left to apply
+	if applied, err = gdb.MigrateUp(); err != nil || len(applied) != 0 {
+		t.Fatalf("applying again: got %d migrations and %v, want none", len(applied), err)
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		reverted, err := gdb.MigrateDown()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reverted.Version != migrations[i].Version {
+			t.Fatalf("reverted %04d, want %04d", reverted.Version, migrations[i].Version)
+		}
+		expectApplied(t, gdb, i)
+	}
+	if _, err = gdb.MigrateDown(); err == nil {
+		t.Fatal("a migration is reverted while none is applied")
+	}
+	if gdb.db.Migrator().HasTable("books") {
+		t.Fatal("the books table is left after reverting every migration")
+	}
+
+	// the down scripts leave a schema the up scripts can build on again
+	if applied, err = gdb.MigrateUp(); err != nil || len(applied) != len(migrations) {
+		t.Fatalf("applying again: got %d migrations and %v, want %d", len(applied), err, len(migrations))
+	}
+	expectApplied(t, gdb, len(migrations))
+}
+
+func TestMigrationsMatchAcrossDrivers(t *testing.T) {
+	postgres, err := loadMigrations(DriverPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := loadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("%d migrations for PostgreSQL and %d for SQLite", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("migration %04d_%s of PostgreSQL is %04d_%s for SQLite",
+				postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+		if sqlite[i].Down == "" {
+			t.Errorf("migration %04d_%s can not be reverted", sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
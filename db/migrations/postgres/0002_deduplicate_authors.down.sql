@@ -0,0 +1,2 @@
+-- Merged authors are not split again, only the lookup index is dropped.
+DROP INDEX IF EXISTS idx_authors_name;
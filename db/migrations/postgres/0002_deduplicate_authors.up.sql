@@ -0,0 +1,32 @@
+-- Authors used to be added once per book. Point every book at the oldest
+-- author the lookup of db.FindAuthor finds: the same name, trimmed and
+-- ignoring case, and the same birthday unless the author has none. Then drop
+-- the duplicates.
+
+UPDATE books
+SET author_id = COALESCE((
+    SELECT MIN(same.id)
+    FROM authors original
+    JOIN authors same
+      ON LOWER(TRIM(same.first_name)) = LOWER(TRIM(original.first_name))
+     AND LOWER(TRIM(same.last_name)) = LOWER(TRIM(original.last_name))
+     AND (COALESCE(original.birthday, '') = '' OR same.birthday = original.birthday)
+     AND same.deleted_at IS NULL
+    WHERE original.id = books.author_id
+), author_id)
+WHERE author_id IS NOT NULL;
+
+UPDATE authors
+SET deleted_at = CURRENT_TIMESTAMP
+WHERE deleted_at IS NULL
+  AND EXISTS (
+    SELECT 1
+    FROM authors older
+    WHERE older.id < authors.id
+      AND older.deleted_at IS NULL
+      AND LOWER(TRIM(older.first_name)) = LOWER(TRIM(authors.first_name))
+      AND LOWER(TRIM(older.last_name)) = LOWER(TRIM(authors.last_name))
+      AND (COALESCE(authors.birthday, '') = '' OR older.birthday = authors.birthday)
+  );
+
+CREATE INDEX IF NOT EXISTS idx_authors_name ON authors (LOWER(last_name), LOWER(first_name));
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_table_of_contents_item_fts;
+DROP INDEX IF EXISTS idx_authors_name_fts;
+DROP INDEX IF EXISTS idx_books_summary_fts;
+DROP INDEX IF EXISTS idx_books_name_fts;
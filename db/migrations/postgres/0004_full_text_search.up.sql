@@ -0,0 +1,7 @@
+-- Expression indexes for the full-text search of books. The expressions must
+-- stay identical to the ones used by searchFullTextQuery in db/search.go.
+
+CREATE INDEX idx_books_name_fts ON books USING GIN (to_tsvector('english', name));
+CREATE INDEX idx_books_summary_fts ON books USING GIN (to_tsvector('english', summary));
+CREATE INDEX idx_authors_name_fts ON authors USING GIN (to_tsvector('english', first_name || ' ' || last_name));
+CREATE INDEX idx_table_of_contents_item_fts ON table_of_contents USING GIN (to_tsvector('english', item));
@@ -0,0 +1,17 @@
+-- Refresh tokens are stored hashed. Every token rotated from the same login
+-- shares its family, which is revoked as a whole when a used token is reused.
+
+CREATE TABLE refresh_tokens (
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    user_id    bigint NOT NULL,
+    family_id  text NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz,
+    revoked_at timestamptz,
+    CONSTRAINT fk_refresh_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
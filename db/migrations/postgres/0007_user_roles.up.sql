@@ -0,0 +1,4 @@
+-- Every existing user becomes a member, admins are promoted with the
+-- "bookman users set-role" command.
+
+ALTER TABLE users ADD COLUMN role text NOT NULL DEFAULT 'member';
@@ -0,0 +1,23 @@
+-- Time-based one-time password secrets of the users who enroll a second
+-- factor. A secret is pending until a code of it is confirmed, and the last
+-- used time step keeps a code from being used twice. Recovery codes are
+-- stored hashed and can be used once instead of a code.
+
+CREATE TABLE two_factors (
+    user_id        bigint PRIMARY KEY,
+    created_at     timestamptz,
+    secret         text NOT NULL,
+    confirmed_at   timestamptz,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    CONSTRAINT fk_two_factors_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE recovery_codes (
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    user_id    bigint NOT NULL,
+    code_hash  text NOT NULL,
+    used_at    timestamptz,
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
@@ -0,0 +1,50 @@
+-- Authors are stored with trimmed names, and a name and birthday can only be
+-- added once. Merge the authors the runtime lookup considers the same, as
+-- 0002 does, since books and contributors may have added some since.
+
+UPDATE authors
+SET first_name = TRIM(first_name),
+    last_name = TRIM(last_name),
+    birthday = TRIM(COALESCE(birthday, '')),
+    nationality = TRIM(COALESCE(nationality, ''));
+
+UPDATE books
+SET author_id = COALESCE((
+    SELECT MIN(same.id)
+    FROM authors original
+    JOIN authors same
+      ON LOWER(same.first_name) = LOWER(original.first_name)
+     AND LOWER(same.last_name) = LOWER(original.last_name)
+     AND (original.birthday = '' OR same.birthday = original.birthday)
+     AND same.deleted_at IS NULL
+    WHERE original.id = books.author_id
+), author_id)
+WHERE author_id IS NOT NULL;
+
+UPDATE book_contributors
+SET author_id = COALESCE((
+    SELECT MIN(same.id)
+    FROM authors original
+    JOIN authors same
+      ON LOWER(same.first_name) = LOWER(original.first_name)
+     AND LOWER(same.last_name) = LOWER(original.last_name)
+     AND (original.birthday = '' OR same.birthday = original.birthday)
+     AND same.deleted_at IS NULL
+    WHERE original.id = book_contributors.author_id
+), author_id);
+
+UPDATE authors
+SET deleted_at = CURRENT_TIMESTAMP
+WHERE deleted_at IS NULL
+  AND EXISTS (
+    SELECT 1
+    FROM authors older
+    WHERE older.id < authors.id
+      AND older.deleted_at IS NULL
+      AND LOWER(older.first_name) = LOWER(authors.first_name)
+      AND LOWER(older.last_name) = LOWER(authors.last_name)
+      AND (authors.birthday = '' OR older.birthday = authors.birthday)
+  );
+
+CREATE UNIQUE INDEX idx_authors_unique_name ON authors (LOWER(first_name), LOWER(last_name), birthday)
+WHERE deleted_at IS NULL;
@@ -0,0 +1,12 @@
+-- Requests for password reset codes, counted per username and per IP address
+-- to throttle them.
+
+CREATE TABLE password_reset_requests (
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz NOT NULL,
+    username   text NOT NULL,
+    ip         text NOT NULL
+);
+CREATE INDEX idx_password_reset_requests_username ON password_reset_requests (username);
+CREATE INDEX idx_password_reset_requests_ip ON password_reset_requests (ip);
+CREATE INDEX idx_password_reset_requests_created_at ON password_reset_requests (created_at);
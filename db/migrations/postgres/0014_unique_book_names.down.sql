@@ -0,0 +1,2 @@
+-- Renamed books keep their new names, only the unique index is dropped.
+DROP INDEX IF EXISTS idx_books_unique_name;
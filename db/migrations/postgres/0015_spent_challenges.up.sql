@@ -0,0 +1,8 @@
+-- Two-factor challenges which completed a login, kept until they expire so
+-- a challenge can only complete one login.
+
+CREATE TABLE spent_challenges (
+    id         text PRIMARY KEY,
+    expires_at timestamptz NOT NULL
+);
+CREATE INDEX idx_spent_challenges_expires_at ON spent_challenges (expires_at);
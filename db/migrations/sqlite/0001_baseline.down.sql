@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS table_of_contents;
+DROP TABLE IF EXISTS books;
+DROP TABLE IF EXISTS authors;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,56 @@
+-- Baseline schema, matching the tables previously created by AutoMigrate.
+-- IF NOT EXISTS lets databases created by AutoMigrate adopt the baseline.
+
+CREATE TABLE IF NOT EXISTS users (
+    id           integer PRIMARY KEY AUTOINCREMENT,
+    created_at   datetime,
+    updated_at   datetime,
+    deleted_at   datetime,
+    username     text,
+    firstname    text,
+    lastname     text,
+    phone_number text,
+    password     text
+);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS authors (
+    id          integer PRIMARY KEY AUTOINCREMENT,
+    created_at  datetime,
+    updated_at  datetime,
+    deleted_at  datetime,
+    first_name  text,
+    last_name   text,
+    birthday    text,
+    nationality text
+);
+CREATE INDEX IF NOT EXISTS idx_authors_deleted_at ON authors (deleted_at);
+
+CREATE TABLE IF NOT EXISTS books (
+    id            integer PRIMARY KEY AUTOINCREMENT,
+    created_at    datetime,
+    updated_at    datetime,
+    deleted_at    datetime,
+    name          text,
+    author_id     integer,
+    created_by_id integer,
+    category      text,
+    volume        integer,
+    published_at  text,
+    summary       text,
+    publisher     text,
+    CONSTRAINT fk_books_author FOREIGN KEY (author_id) REFERENCES authors (id) ON DELETE CASCADE,
+    CONSTRAINT fk_books_created_by FOREIGN KEY (created_by_id) REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS idx_books_deleted_at ON books (deleted_at);
+
+CREATE TABLE IF NOT EXISTS table_of_contents (
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    book_id    integer,
+    item       text,
+    CONSTRAINT fk_books_table_of_contents FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_table_of_contents_deleted_at ON table_of_contents (deleted_at);
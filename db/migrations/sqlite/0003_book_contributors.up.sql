@@ -0,0 +1,20 @@
+-- Books can have several authors, translators and editors. The current
+-- author of every book becomes its first contributor.
+
+CREATE TABLE book_contributors (
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    book_id    integer NOT NULL,
+    author_id  integer NOT NULL,
+    role       text NOT NULL DEFAULT 'author',
+    position   integer NOT NULL DEFAULT 0,
+    CONSTRAINT fk_books_contributors FOREIGN KEY (book_id) REFERENCES books (id) ON DELETE CASCADE,
+    CONSTRAINT fk_book_contributors_author FOREIGN KEY (author_id) REFERENCES authors (id)
+);
+CREATE INDEX idx_book_contributors_book_id ON book_contributors (book_id);
+CREATE INDEX idx_book_contributors_author_id ON book_contributors (author_id);
+
+INSERT INTO book_contributors (created_at, book_id, author_id, role, position)
+SELECT CURRENT_TIMESTAMP, id, author_id, 'author', 0
+FROM books
+WHERE author_id IS NOT NULL;
@@ -0,0 +1,2 @@
+-- Full-text search indexes are PostgreSQL only, SQLite uses the portable
+-- search of db/search.go which has nothing to index.
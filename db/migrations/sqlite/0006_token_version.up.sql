@@ -0,0 +1,4 @@
+-- Bumping the token version of a user invalidates every access token issued
+-- to the user before.
+
+ALTER TABLE users ADD COLUMN token_version integer NOT NULL DEFAULT 0;
@@ -0,0 +1,14 @@
+-- Password reset codes are stored hashed and can be used once before they
+-- expire. Requesting a new code drops the unused codes of the user.
+
+CREATE TABLE password_resets (
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    user_id    integer NOT NULL,
+    code_hash  text NOT NULL,
+    expires_at datetime NOT NULL,
+    used_at    datetime,
+    CONSTRAINT fk_password_resets_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX idx_password_resets_code_hash ON password_resets (code_hash);
+CREATE INDEX idx_password_resets_user_id ON password_resets (user_id);
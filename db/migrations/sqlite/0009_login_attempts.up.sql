@@ -0,0 +1,13 @@
+-- Failed logins, counted per username and per IP address to slow down and
+-- lock out password guessing. Successful logins clear the failures of their
+-- username.
+
+CREATE TABLE login_attempts (
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime NOT NULL,
+    username   text NOT NULL,
+    ip         text NOT NULL
+);
+CREATE INDEX idx_login_attempts_username ON login_attempts (username);
+CREATE INDEX idx_login_attempts_ip ON login_attempts (ip);
+CREATE INDEX idx_login_attempts_created_at ON login_attempts (created_at);
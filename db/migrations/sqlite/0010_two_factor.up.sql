@@ -0,0 +1,23 @@
+-- Time-based one-time password secrets of the users who enroll a second
+-- factor. A secret is pending until a code of it is confirmed, and the last
+-- used time step keeps a code from being used twice. Recovery codes are
+-- stored hashed and can be used once instead of a code.
+
+CREATE TABLE two_factors (
+    user_id        integer PRIMARY KEY,
+    created_at     datetime,
+    secret         text NOT NULL,
+    confirmed_at   datetime,
+    last_used_step integer NOT NULL DEFAULT 0,
+    CONSTRAINT fk_two_factors_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE recovery_codes (
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    user_id    integer NOT NULL,
+    code_hash  text NOT NULL,
+    used_at    datetime,
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
@@ -0,0 +1,2 @@
+-- Merged authors are not split again, only the unique index is dropped.
+DROP INDEX IF EXISTS idx_authors_unique_name;
@@ -0,0 +1,2 @@
+-- Cleared phone numbers are not given back, only the unique index is dropped.
+DROP INDEX IF EXISTS idx_users_unique_phone_number;
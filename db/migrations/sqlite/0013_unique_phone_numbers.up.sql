@@ -0,0 +1,17 @@
+-- A phone number can only belong to one user, users may leave it empty. The
+-- oldest user keeps a phone number given more than once, the others lose it.
+
+UPDATE users
+SET phone_number = ''
+WHERE phone_number <> ''
+  AND deleted_at IS NULL
+  AND EXISTS (
+    SELECT 1
+    FROM users older
+    WHERE older.id < users.id
+      AND older.deleted_at IS NULL
+      AND older.phone_number = users.phone_number
+  );
+
+CREATE UNIQUE INDEX idx_users_unique_phone_number ON users (phone_number)
+WHERE phone_number <> '' AND deleted_at IS NULL;
@@ -0,0 +1,17 @@
+-- A book name can only be used once among the books which are not deleted.
+-- The oldest book keeps a name used more than once, the others get their ID
+-- appended so no book is lost.
+
+UPDATE books
+SET name = name || ' (' || CAST(id AS text) || ')'
+WHERE deleted_at IS NULL
+  AND EXISTS (
+    SELECT 1
+    FROM books older
+    WHERE older.id < books.id
+      AND older.deleted_at IS NULL
+      AND older.name = books.name
+  );
+
+CREATE UNIQUE INDEX idx_books_unique_name ON books (name)
+WHERE deleted_at IS NULL;
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultBcryptCost is used when the configuration does not set a cost
+const DefaultBcryptCost = 12
+
+func (gdb *GormDB) bcryptCost() int {
+	if cost := gdb.cfg.Password.BcryptCost; cost != 0 {
+		return cost
+	}
+	return DefaultBcryptCost
+}
+
+// HashPassword hashes the password with bcrypt at the configured cost
+func (gdb *GormDB) HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), gdb.bcryptCost())
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// PasswordNeedsRehash reports whether the bcrypt hash of a password was made
+// with another cost than the configured one
+func (gdb *GormDB) PasswordNeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != gdb.bcryptCost()
+}
+
+// SetUserPassword hashes the password and stores it as the password of the
+// user
+func (gdb *GormDB) SetUserPassword(userID uint, password string) error {
+	hash, err := gdb.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	result := gdb.db.Model(&User{}).Where("id = ?", userID).Update("password", hash)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// checkBcryptCost makes sure passwords can be hashed with the configured cost
+func checkBcryptCost(cost int) error {
+	if cost != 0 && (cost < bcrypt.MinCost || cost > bcrypt.MaxCost) {
+		return fmt.Errorf("the bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PasswordReset is a single-use code letting a user choose a new password
+// without the old one. Only the hash of the code is stored.
+type PasswordReset struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint
+	CodeHash  string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// PasswordResetRequest is a request for a reset code, kept for a while to
+// throttle the requests of a username and of an IP address
+type PasswordResetRequest struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Username  string
+	IP        string
+}
+
+var ErrPasswordResetUsed = errors.New("the password reset code is already used")
+
+// CreatePasswordReset stores the reset code, dropping the unused codes the
+// user was sent before
+func (gdb *GormDB) CreatePasswordReset(reset *PasswordReset) error {
+	return gdb.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND used_at IS NULL", reset.UserID).Delete(&PasswordReset{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(reset).Error
+	})
+}
+
+func (gdb *GormDB) GetPasswordResetByHash(codeHash string) (*PasswordReset, error) {
+	var reset PasswordReset
+	err := gdb.db.Where("code_hash = ?", codeHash).First(&reset).Error
+	if err != nil {
+		return nil, err
+	}
+	return &reset, nil
+}
+
+// CompletePasswordReset marks the code as used, sets the new password of its
+// user and ends every session of the user. Only one of several concurrent
+// uses of a code succeeds, the others get ErrPasswordResetUsed.
+func (gdb *GormDB) CompletePasswordReset(reset *PasswordReset, password string) error {
+	hash, err := gdb.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return gdb.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&PasswordReset{}).
+			Where("id = ? AND used_at IS NULL", reset.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPasswordResetUsed
+		}
+		reset.UsedAt = &now
+		if err := tx.Model(&User{}).Where("id = ?", reset.UserID).Update("password", hash).Error; err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, reset.UserID)
+	})
+}
+
+// ChangeUserPassword sets the new password of the user and ends every
+// session of the user
+func (gdb *GormDB) ChangeUserPassword(userID uint, password string) error {
+	hash, err := gdb.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return gdb.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ?", userID).Update("password", hash)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return revokeUserSessions(tx, userID)
+	})
+}
+
+// ReservePasswordResetRequest stores the request and drops the requests made
+// before keepSince, which no longer count
+func (gdb *GormDB) ReservePasswordResetRequest(request *PasswordResetRequest, keepSince time.Time) error {
+	if err := gdb.db.Create(request).Error; err != nil {
+		return err
+	}
+	return gdb.db.Where("created_at < ?", keepSince).Delete(&PasswordResetRequest{}).Error
+}
+
+// GetPasswordResetRequests returns the requests of the username or of the IP
+// address made since the given time, oldest first
+func (gdb *GormDB) GetPasswordResetRequests(username, ip string, since time.Time) ([]PasswordResetRequest, error) {
+	var requests []PasswordResetRequest
+	err := gdb.db.Where("(username = ? OR ip = ?) AND created_at >= ?", username, ip, since).
+		Order("created_at, id").
+		Find(&requests).Error
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// DeletePasswordResetRequest removes a request which was refused
+func (gdb *GormDB) DeletePasswordResetRequest(id uint) error {
+	return gdb.db.Delete(&PasswordResetRequest{}, id).Error
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken is a single-use token exchanged for a new access token. Only
+// the hash of the token is stored.
+type RefreshToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+var ErrRefreshTokenUsed = errors.New("the refresh token is already used")
+
+func (gdb *GormDB) CreateRefreshToken(token *RefreshToken) error {
+	return gdb.db.Create(token).Error
+}
+
+func (gdb *GormDB) GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	err := gdb.db.Where("token_hash = ?", tokenHash).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken marks the token as used and stores the next token of its
+// family. Only one of several concurrent rotations of a token succeeds, the
+// others get ErrRefreshTokenUsed.
+func (gdb *GormDB) RotateRefreshToken(used *RefreshToken, next *RefreshToken) error {
+	return gdb.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", used.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenUsed
+		}
+		used.UsedAt = &now
+		return tx.Create(next).Error
+	})
+}
+
+// RevokeRefreshTokenFamily revokes every token rotated from the same login
+func (gdb *GormDB) RevokeRefreshTokenFamily(familyID string) error {
+	return gdb.db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// IsRefreshTokenFamilyRevoked reports whether the session of the family ended
+func (gdb *GormDB) IsRefreshTokenFamilyRevoked(familyID string) (bool, error) {
+	var count int64
+	err := gdb.db.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NOT NULL", familyID).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,311 @@
+package db
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Fields of a book a search can match
+const (
+	SearchFieldName            = "name"
+	SearchFieldSummary         = "summary"
+	SearchFieldContributor     = "contributor"
+	SearchFieldTableOfContents = "table_of_contents"
+)
+
+// searchFieldWeights ranks a match in the name above one in the contributors,
+// the table of contents and finally the summary
+var searchFieldWeights = map[string]float64{
+	SearchFieldName:            4,
+	SearchFieldContributor:     3,
+	SearchFieldTableOfContents: 2,
+	SearchFieldSummary:         1,
+}
+
+// Snippets are HTML with the matches wrapped in mark elements. The full-text
+// search marks them with control characters first, so the stored text can be
+// escaped without escaping the marks.
+const (
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+
+	fullTextStart = "\x01"
+	fullTextStop  = "\x02"
+)
+
+const (
+	// searchMinWordLength keeps the portable search from matching nearly
+	// every row with words of one letter
+	searchMinWordLength = 3
+	// searchRowLimit is the most rows the portable search reads per field
+	searchRowLimit = 500
+)
+
+type SearchSnippet struct {
+	Field string
+	Text  string
+}
+
+// SearchHit is a book matching a search with the parts of it that matched
+type SearchHit struct {
+	Book     Book
+	Rank     float64
+	Snippets []SearchSnippet
+}
+
+// searchRow is a field of a book the portable search looks into
+type searchRow struct {
+	BookID uint
+	Text   string
+}
+
+// searchMatch is one matching field of a book
+type searchMatch struct {
+	BookID  uint
+	Field   string
+	Snippet string
+	Rank    float64
+}
+
+// SearchBooks ranks the books matching the text in their name, summary,
+// contributors or table of contents. PostgreSQL uses its full-text search,
+// other drivers fall back to matching every word of the text.
+func (gdb *GormDB) SearchBooks(text string, limit int) ([]SearchHit, error) {
+	var matches []searchMatch
+	var err error
+	if gdb.driver() == DriverPostgres {
+		matches, err = gdb.searchMatchesFullText(text)
+	} else {
+		matches, err = gdb.searchMatchesPortable(text)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// group the matching fields by book
+	hitsByBook := map[uint]*SearchHit{}
+	var bookIDs []uint
+	for _, m := range matches {
+		hit, ok := hitsByBook[m.BookID]
+		if !ok {
+			hit = &SearchHit{}
+			hitsByBook[m.BookID] = hit
+			bookIDs = append(bookIDs, m.BookID)
+		}
+		hit.Rank += m.Rank * searchFieldWeights[m.Field]
+		hit.Snippets = append(hit.Snippets, SearchSnippet{Field: m.Field, Text: m.Snippet})
+	}
+	sort.Slice(bookIDs, func(i, j int) bool {
+		ri, rj := hitsByBook[bookIDs[i]].Rank, hitsByBook[bookIDs[j]].Rank
+		if ri != rj {
+			return ri > rj
+		}
+		return bookIDs[i] < bookIDs[j]
+	})
+	if len(bookIDs) > limit {
+		bookIDs = bookIDs[:limit]
+	}
+	if len(bookIDs) == 0 {
+		return []SearchHit{}, nil
+	}
+
+	var books []Book
+	if err = preloadBookDetails(&gdb.db).Where("id IN ?", bookIDs).Find(&books).Error; err != nil {
+		return nil, err
+	}
+	for _, book := range books {
+		hitsByBook[book.ID].Book = book
+	}
+
+	hits := make([]SearchHit, 0, len(bookIDs))
+	for _, id := range bookIDs {
+		// skip matches of books deleted in the meantime
+		if hit := hitsByBook[id]; hit.Book.ID != 0 {
+			hits = append(hits, *hit)
+		}
+	}
+	return hits, nil
+}
+
+// searchFullTextQuery matches every field with the PostgreSQL full-text search,
+// the expressions are the ones indexed by the full_text_search migration
+const searchFullTextQuery = `
+WITH q AS (SELECT websearch_to_tsquery('english', @text) AS query)
+SELECT b.id AS book_id, 'name' AS field,
+       ts_headline('english', b.name, q.query, @options) AS snippet,
+       ts_rank(to_tsvector('english', b.name), q.query) AS rank
+FROM books b, q
+WHERE b.deleted_at IS NULL AND to_tsvector('english', b.name) @@ q.query
+UNION ALL
+SELECT b.id, 'summary',
+       ts_headline('english', b.summary, q.query, @options),
+       ts_rank(to_tsvector('english', b.summary), q.query)
+FROM books b, q
+WHERE b.deleted_at IS NULL AND to_tsvector('english', b.summary) @@ q.query
+UNION ALL
+SELECT bc.book_id, 'contributor',
+       ts_headline('english', a.first_name || ' ' || a.last_name, q.query, @options),
+       ts_rank(to_tsvector('english', a.first_name || ' ' || a.last_name), q.query)
+FROM book_contributors bc
+JOIN authors a ON a.id = bc.author_id AND a.deleted_at IS NULL
+JOIN books b ON b.id = bc.book_id AND b.deleted_at IS NULL, q
+WHERE to_tsvector('english', a.first_name || ' ' || a.last_name) @@ q.query
+UNION ALL
+SELECT t.book_id, 'table_of_contents',
+       ts_headline('english', t.item, q.query, @options),
+       ts_rank(to_tsvector('english', t.item), q.query)
+FROM table_of_contents t
+JOIN books b ON b.id = t.book_id AND b.deleted_at IS NULL, q
+WHERE t.deleted_at IS NULL AND to_tsvector('english', t.item) @@ q.query`
+
+func (gdb *GormDB) searchMatchesFullText(text string) ([]searchMatch, error) {
+	var matches []searchMatch
+	err := gdb.db.Raw(searchFullTextQuery, map[string]interface{}{
+		"text":    text,
+		"options": "StartSel=" + fullTextStart + ", StopSel=" + fullTextStop + ", MaxWords=20, MinWords=5",
+	}).Scan(&matches).Error
+	if err != nil {
+		return nil, err
+	}
+	marks := strings.NewReplacer(fullTextStart, highlightStart, fullTextStop, highlightStop)
+	for i := range matches {
+		matches[i].Snippet = marks.Replace(html.EscapeString(matches[i].Snippet))
+	}
+	return matches, nil
+}
+
+var searchWordPattern = regexp.MustCompile(`[\pL\pN]+`)
+
+// searchMatchesPortable finds the fields containing any word of the text and
+// ranks them by the share of the words they contain. Words shorter than
+// searchMinWordLength are ignored.
+func (gdb *GormDB) searchMatchesPortable(text string) ([]searchMatch, error) {
+	var words []string
+	for _, word := range searchWordPattern.FindAllString(strings.ToLower(text), -1) {
+		if utf8.RuneCountInString(word) >= searchMinWordLength {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	anyWord := func(column string) (string, []interface{}) {
+		var conditions []string
+		var args []interface{}
+		for _, word := range words {
+			conditions = append(conditions, "LOWER("+column+") LIKE ? ESCAPE '\\'")
+			args = append(args, containsPattern(word))
+		}
+		return "(" + strings.Join(conditions, " OR ") + ")", args
+	}
+
+	var matches []searchMatch
+	addMatches := func(field string, query *gorm.DB) error {
+		var rows []searchRow
+		if err := query.Limit(searchRowLimit).Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			rank, snippet := matchWords(row.Text, words, field == SearchFieldSummary)
+			if rank > 0 {
+				matches = append(matches, searchMatch{BookID: row.BookID, Field: field, Snippet: snippet, Rank: rank})
+			}
+		}
+		return nil
+	}
+
+	for _, field := range []string{SearchFieldName, SearchFieldSummary} {
+		condition, args := anyWord("books." + field)
+		err := addMatches(field, gdb.db.Model(&Book{}).
+			Select("books.id AS book_id, books."+field+" AS text").
+			Where(condition, args...))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fullName := "authors.first_name || ' ' || authors.last_name"
+	condition, args := anyWord(fullName)
+	err := addMatches(SearchFieldContributor, gdb.db.Model(&BookContributor{}).
+		Select("book_contributors.book_id, "+fullName+" AS text").
+		Joins("JOIN authors ON authors.id = book_contributors.author_id AND authors.deleted_at IS NULL").
+		Joins("JOIN books ON books.id = book_contributors.book_id AND books.deleted_at IS NULL").
+		Where(condition, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	condition, args = anyWord("table_of_contents.item")
+	err = addMatches(SearchFieldTableOfContents, gdb.db.Model(&TableOfContent{}).
+		Select("table_of_contents.book_id, table_of_contents.item AS text").
+		Joins("JOIN books ON books.id = table_of_contents.book_id AND books.deleted_at IS NULL").
+		Where(condition, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+// matchWords returns the share of the words found in the text and the text
+// escaped as HTML with the words highlighted, shortened around the first
+// match if asked
+func matchWords(text string, words []string, shorten bool) (float64, string) {
+	lower := strings.ToLower(text)
+	found := 0
+	first := -1
+	for _, word := range words {
+		if i := strings.Index(lower, word); i >= 0 {
+			found++
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+	if found == 0 {
+		return 0, ""
+	}
+
+	const window = 60
+	if shorten && len(text) > 2*window {
+		start, end := first-window, first+window
+		if start < 0 {
+			start = 0
+		}
+		if end > len(text) {
+			end = len(text)
+		}
+		// do not cut a word, or a multi-byte character, in half
+		for start > 0 && text[start-1] != ' ' {
+			start--
+		}
+		for end < len(text) && text[end] != ' ' {
+			end++
+		}
+		text = strings.TrimSpace(text[start:end])
+	}
+
+	var pattern []string
+	for _, word := range words {
+		pattern = append(pattern, regexp.QuoteMeta(word))
+	}
+	highlighter := regexp.MustCompile("(?i)" + strings.Join(pattern, "|"))
+
+	// escape the text around the matches, the words themselves are only
+	// letters and digits
+	var snippet strings.Builder
+	last := 0
+	for _, m := range highlighter.FindAllStringIndex(text, -1) {
+		snippet.WriteString(html.EscapeString(text[last:m[0]]))
+		snippet.WriteString(highlightStart + text[m[0]:m[1]] + highlightStop)
+		last = m[1]
+	}
+	snippet.WriteString(html.EscapeString(text[last:]))
+	return float64(found) / float64(len(words)), snippet.String()
+}
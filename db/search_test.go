@@ -0,0 +1,35 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestSearchSkipsDeletedBooksAndEscapesSnippets(t *testing.T) {
+	gdb := newTestDB(t)
+	seedBooks(t, gdb, 2)
+	if err := gdb.DeleteBookByID(1); err != nil {
+		t.Fatal(err)
+	}
+	err := gdb.db.Model(&Book{}).Where("id = ?", 2).Update("summary", "<b>first</b> & more").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hits, err := gdb.SearchBooks("first", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Book.ID != 2 {
+		t.Fatalf("expected only book 2, got %+v", hits)
+	}
+	for _, snippet := range hits[0].Snippets {
+		if snippet.Field == SearchFieldSummary && snippet.Text != "&lt;b&gt;<mark>first</mark>&lt;/b&gt; &amp; more" {
+			t.Errorf("summary snippet not escaped: %q", snippet.Text)
+		}
+	}
+
+	// too short to search for
+	if hits, err = gdb.SearchBooks("fi", 10); err != nil || len(hits) != 0 {
+		t.Errorf("expected no hits for a short word, got %+v, %v", hits, err)
+	}
+}
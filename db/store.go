@@ -0,0 +1,92 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is returned when the requested record does not exist
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// Store is the storage abstraction used by the handlers and authenticate
+// packages. GormDB is the default implementation; alternative backends and
+// fakes only need to satisfy this interface.
+type Store interface {
+	// Schema
+	CreateSchema() error
+
+	// Users
+	CreateNewUser(u *User) error
+	GetUserByUsername(username string) (*User, error)
+	GetUsernameByID(userID uint) (*string, error)
+	GetUserByID(userID uint) (*User, error)
+	RevokeUserSessions(userID uint) error
+	SetUserRole(userID uint, role string) error
+	HashPassword(password string) (string, error)
+	SetUserPassword(userID uint, password string) error
+	PasswordNeedsRehash(hash string) bool
+	ChangeUserPassword(userID uint, password string) error
+	UpdateUserProfile(userID uint, update ProfileUpdate) (*User, error)
+	DeleteUser(userID uint) error
+	CheckBookHeir() error
+
+	// Password resets
+	CreatePasswordReset(reset *PasswordReset) error
+	GetPasswordResetByHash(codeHash string) (*PasswordReset, error)
+	CompletePasswordReset(reset *PasswordReset, password string) error
+	ReservePasswordResetRequest(request *PasswordResetRequest, keepSince time.Time) error
+	GetPasswordResetRequests(username, ip string, since time.Time) ([]PasswordResetRequest, error)
+	DeletePasswordResetRequest(id uint) error
+
+	// Two-factor authentication
+	SaveTwoFactorSecret(userID uint, secret string) error
+	GetTwoFactor(userID uint) (*TwoFactor, error)
+	ConfirmTwoFactor(userID uint, step int64, codeHashes []string) error
+	UseTwoFactorStep(userID uint, step int64) error
+	UseRecoveryCode(userID uint, codeHash string) error
+	DeleteTwoFactor(userID uint) error
+	SpendChallenge(id string, expiresAt, now time.Time) error
+
+	// Login attempts
+	ReserveLoginAttempt(attempt *LoginAttempt, keepSince time.Time) error
+	GetLoginAttempts(username, ip string, since time.Time) ([]LoginAttempt, error)
+	GetAllLoginAttempts(since time.Time) ([]LoginAttempt, error)
+	DeleteLoginAttempt(id uint) error
+	ClearLoginFailures(username, ip string) error
+
+	// Refresh tokens
+	CreateRefreshToken(token *RefreshToken) error
+	GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error)
+	RotateRefreshToken(used *RefreshToken, next *RefreshToken) error
+	RevokeRefreshTokenFamily(familyID string) error
+	IsRefreshTokenFamilyRevoked(familyID string) (bool, error)
+
+	// Books
+	CreateNewBook(newBook *Book) error
+	GetCreatedByUsernameByID(bookID uint) (*string, error)
+	DeleteBookByID(bookID uint) error
+	UpdateBookByID(book *Book, bookID uint) (*Book, error)
+	ReplaceBookByID(book *Book, bookID uint) (*Book, error)
+	GetAllBooks() ([]Book, error)
+	FindBooks(filter BookFilter, page Page) (*BookPage, error)
+	SearchBooks(text string, limit int) ([]SearchHit, error)
+	GetABookByID(bookId uint) (*Book, error)
+
+	// Authors
+	CreateNewAuthor(newAuthor *Author) error
+	FindAuthor(author Author) (*Author, error)
+	FindOrCreateAuthor(author *Author) (*Author, error)
+	GetAllAuthors() ([]Author, error)
+	GetAuthorByID(authorID uint) (*Author, error)
+	UpdateAuthorByID(author *Author, authorID uint) (*Author, error)
+	DeleteAuthorByID(authorID uint) error
+
+	// Contributors
+	GetContributorsByBookID(bookID uint) ([]BookContributor, error)
+
+	// Table of contents
+	GetContentsByBookID(bookID uint) ([]string, error)
+}
+
+var _ Store = (*GormDB)(nil)
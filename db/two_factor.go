@@ -0,0 +1,149 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TwoFactor is the time-based one-time password secret of a user. It only
+// protects logins once ConfirmedAt is set.
+type TwoFactor struct {
+	UserID      uint `gorm:"primarykey;autoIncrement:false"`
+	CreatedAt   time.Time
+	Secret      string
+	ConfirmedAt *time.Time
+	// LastUsedStep is the time step of the last accepted code
+	LastUsedStep int64
+}
+
+// RecoveryCode replaces a one-time password once, when the user lost the
+// device generating them. Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint
+	CodeHash  string
+	UsedAt    *time.Time
+}
+
+// SpentChallenge is a two-factor challenge which completed a login, kept
+// until it expires so it can not complete another
+type SpentChallenge struct {
+	ID        string `gorm:"primarykey"`
+	ExpiresAt time.Time
+}
+
+var (
+	ErrTwoFactorEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorCodeUsed = errors.New("the two-factor code is already used")
+	ErrChallengeSpent    = errors.New("the challenge is already used")
+)
+
+// SaveTwoFactorSecret stores a pending secret of the user, replacing the one
+// enrolled before unless it is confirmed
+func (gdb *GormDB) SaveTwoFactorSecret(userID uint, secret string) error {
+	return gdb.db.Transaction(func(tx *gorm.DB) error {
+		var existing TwoFactor
+		err := tx.Where("user_id = ?", userID).First(&existing).Error
+		if err == nil && existing.ConfirmedAt != nil {
+			return ErrTwoFactorEnabled
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err = tx.Where("user_id = ?", userID).Delete(&TwoFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&TwoFactor{UserID: userID, Secret: secret}).Error
+	})
+}
+
+func (gdb *GormDB) GetTwoFactor(userID uint) (*TwoFactor, error) {
+	var twoFactor TwoFactor
+	err := gdb.db.Where("user_id = ?", userID).First(&twoFactor).Error
+	if err != nil {
+		return nil, err
+	}
+	return &twoFactor, nil
+}
+
+// ConfirmTwoFactor enables the pending secret of the user, on which the code
+// of the time step was accepted, and replaces the recovery codes of the user
+func (gdb *GormDB) ConfirmTwoFactor(userID uint, step int64, codeHashes []string) error {
+	return gdb.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&TwoFactor{}).
+			Where("user_id = ? AND confirmed_at IS NULL", userID).
+			Updates(map[string]interface{}{"confirmed_at": time.Now(), "last_used_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTwoFactorEnabled
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]RecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseTwoFactorStep accepts a code of the time step once. A code of the same
+// or an earlier step than the last accepted one gets ErrTwoFactorCodeUsed.
+func (gdb *GormDB) UseTwoFactorStep(userID uint, step int64) error {
+	result := gdb.db.Model(&TwoFactor{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTwoFactorCodeUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the unused recovery code of the user as used, or
+// returns ErrNotFound when there is no such code
+func (gdb *GormDB) UseRecoveryCode(userID uint, codeHash string) error {
+	result := gdb.db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SpendChallenge records that the challenge completed a login, or returns
+// ErrChallengeSpent when it did already. The challenges expired before now
+// are forgotten.
+func (gdb *GormDB) SpendChallenge(id string, expiresAt, now time.Time) error {
+	err := gdb.db.Create(&SpentChallenge{ID: id, ExpiresAt: expiresAt}).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrChallengeSpent
+	}
+	if err != nil {
+		return err
+	}
+	return gdb.db.Where("expires_at < ?", now).Delete(&SpentChallenge{}).Error
+}
+
+// DeleteTwoFactor turns two-factor authentication off for the user and drops
+// its recovery codes
+func (gdb *GormDB) DeleteTwoFactor(userID uint) error {
+	return gdb.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&TwoFactor{}).Error
+	})
+}
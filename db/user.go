@@ -2,8 +2,8 @@ package db
 
 import (
 	"errors"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -14,23 +14,61 @@ type User struct {
 	Lastname    string `gorm:"varchar(25)"`
 	PhoneNumber string `gorm:"varchar(15), unique"`
 	Password    string `gorm:"varchar(25)"`
+	// TokenVersion is bumped to invalidate every access token of the user
+	TokenVersion uint
+	Role         string
+}
+
+// Roles of users, from the most to the least privileged
+const (
+	RoleAdmin     = "admin"
+	RoleLibrarian = "librarian"
+	RoleMember    = "member"
+	RoleReadOnly  = "readonly"
+)
+
+var (
+	ErrInvalidRole      = errors.New("the role must be one of admin, librarian, member or readonly")
+	ErrUsernameTaken    = errors.New("this username is already taken")
+	ErrPhoneNumberTaken = errors.New("this phone number is already used")
+)
+
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleLibrarian, RoleMember, RoleReadOnly:
+		return true
+	}
+	return false
 }
 
 func (gdb *GormDB) CreateNewUser(u *User) error {
 	// Encrypting the user password
-	if encryptedPW, err := bcrypt.GenerateFromPassword([]byte(u.Password), 4); err != nil {
+	if encryptedPW, err := gdb.HashPassword(u.Password); err != nil {
 		return err
 	} else {
-		u.Password = string(encryptedPW)
+		u.Password = encryptedPW
+	}
+
+	if u.Role == "" {
+		u.Role = RoleMember
+	} else if !IsValidRole(u.Role) {
+		return ErrInvalidRole
 	}
 
 	// check duplicate user
 	var count int64
 	if gdb.db.Model(&User{}).Where("username = ?", u.Username).Count(&count); count > 0 {
-		return errors.New("this username is already taken")
+		return ErrUsernameTaken
 	}
-	return gdb.db.Create(u).Error
-
+	if err := checkPhoneNumberFree(&gdb.db, u.PhoneNumber, 0); err != nil {
+		return err
+	}
+	// the unique index settles a phone number two sign ups checked at once
+	err := gdb.db.Create(u).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrPhoneNumberTaken
+	}
+	return err
 }
 
 func (gdb *GormDB) GetUserByUsername(username string) (*User, error) {
@@ -50,3 +88,46 @@ func (gdb *GormDB) GetUsernameByID(userID uint) (*string, error) {
 	}
 	return &user.Username, nil
 }
+
+func (gdb *GormDB) GetUserByID(userID uint) (*User, error) {
+	var user User
+	err := gdb.db.Where("id = ?", userID).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RevokeUserSessions invalidates every access and refresh token of the user
+func (gdb *GormDB) RevokeUserSessions(userID uint) error {
+	return gdb.db.Transaction(func(tx *gorm.DB) error {
+		return revokeUserSessions(tx, userID)
+	})
+}
+
+// revokeUserSessions outdates the access tokens of the user and revokes its
+// refresh tokens within the transaction
+func revokeUserSessions(tx *gorm.DB, userID uint) error {
+	err := tx.Model(&User{}).Where("id = ?", userID).
+		Update("token_version", gorm.Expr("token_version + 1")).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (gdb *GormDB) SetUserRole(userID uint, role string) error {
+	if !IsValidRole(role) {
+		return ErrInvalidRole
+	}
+	result := gdb.db.Model(&User{}).Where("id = ?", userID).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -2,19 +2,22 @@ module bookman
 
 go 1.20
 
-require github.com/sirupsen/logrus v1.9.3
+require (
+	github.com/golang-jwt/jwt/v5 v5.0.0
+	github.com/gorilla/mux v1.8.0
+	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/crypto v0.8.0
+)
 
 require (
 	github.com/BurntSushi/toml v1.2.1 // indirect
-	github.com/golang-jwt/jwt/v5 v5.0.0 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
 	github.com/jackc/pgx/v5 v5.3.1 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/crypto v0.8.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.17 // indirect
 	golang.org/x/text v0.9.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	olympos.io/encoding/edn v0.0.0-20201019073823-d3554ca0b0a3 // indirect
@@ -24,5 +27,6 @@ require (
 	github.com/ilyakaznacheev/cleanenv v1.5.0
 	golang.org/x/sys v0.7.0 // indirect
 	gorm.io/driver/postgres v1.5.2
+	gorm.io/driver/sqlite v1.5.3
 	gorm.io/gorm v1.25.4
 )
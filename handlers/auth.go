@@ -4,8 +4,12 @@ import (
 	"bookman/authenticate"
 	"bookman/db"
 	"encoding/json"
+	"errors"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type signupRequest struct {
@@ -15,75 +19,169 @@ type signupRequest struct {
 	Password    string `json:"password"`
 	PhoneNumber string `json:"phone_number"`
 }
+
+// validate checks a new user before it is stored
+func (sr *signupRequest) validate(passwords *authenticate.PasswordPolicy) []fieldError {
+	return validate(
+		field("username", sr.Username, required(), minLength(3), maxLength(25),
+			matches(usernamePattern, "may only contain letters, digits, dots, dashes and underscores")),
+		field("password", sr.Password, required(), passwordPolicy(passwords, sr.Username)),
+		field("firstname", sr.Firstname, maxLength(25)),
+		field("lastname", sr.Lastname, maxLength(25)),
+		field("phone_number", sr.PhoneNumber, matches(phoneNumberPattern, "must be a phone number of digits, optionally starting with +")),
+	)
+}
+
 type loginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
 func (bm *BookManagerServer) HandleLogin(w http.ResponseWriter, r *http.Request) {
-	// Check Method
-	if r.Method != http.MethodPost {
-		bm.Logger.Warn("the signup api is not called by POST method")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Parse the request body for login user
 	reqData, err := io.ReadAll(r.Body)
 	if err != nil {
-		bm.Logger.Warn("can not read the body of the request")
-		w.WriteHeader(http.StatusBadRequest)
+		bm.invalidBody(w, err)
 		return
 	}
 
 	var lr loginRequest
 	err = json.Unmarshal(reqData, &lr)
 	if err != nil {
-		bm.Logger.Warn("can not unmarshal the login request body")
-		w.WriteHeader(http.StatusBadRequest)
+		bm.invalidBody(w, err)
 		return
 	}
 
 	// Use authenticate package to validate the credentials
+	ip := bm.clientIP(r)
 	token, err := bm.Authenticate.Login(authenticate.Credentials{
 		Username: lr.Username,
 		Password: lr.Password,
+		IP:       ip,
 	})
+	var secondFactor *authenticate.SecondFactorRequiredError
+	if errors.As(err, &secondFactor) {
+		writeChallenge(w, secondFactor)
+		return
+	}
+	if bm.lockedOut(w, err, lr.Username, ip) {
+		return
+	}
+	if errors.Is(err, authenticate.ErrInvalidCredentials) {
+		bm.Logger.WithField("ip", ip).Warn("can not login ", lr.Username)
+		writeProblem(w, http.StatusUnauthorized, codeInvalidCredentials, err.Error())
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("can not login"))
+		bm.internalError(w, err, "can not login ", lr.Username)
 		return
 	}
 
+	writeTokens(w, token)
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (bm *BookManagerServer) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	// Parse the request body for the refresh token
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	var rr refreshRequest
+	err = json.Unmarshal(reqData, &rr)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	// Exchange the refresh token for a new pair of tokens
+	token, err := bm.Authenticate.Refresh(rr.RefreshToken)
+	if errors.Is(err, authenticate.ErrInvalidRefreshToken) {
+		writeProblem(w, http.StatusUnauthorized, codeInvalidRefreshToken, err.Error())
+		return
+	}
+	if errors.Is(err, authenticate.ErrRefreshTokenReused) {
+		writeProblem(w, http.StatusUnauthorized, codeRefreshTokenReused, err.Error())
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not refresh the tokens")
+		return
+	}
+
+	writeTokens(w, token)
+}
+
+func (bm *BookManagerServer) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	bm.handleLogout(w, r, bm.Authenticate.Logout)
+}
+
+func (bm *BookManagerServer) HandleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	bm.handleLogout(w, r, bm.Authenticate.LogoutAll)
+}
+
+func (bm *BookManagerServer) handleLogout(w http.ResponseWriter, r *http.Request, logout func(token string) error) {
+	//	The token is already checked by the authentication middleware
+	if err := logout(bearerToken(r)); err != nil {
+		bm.internalError(w, err, "can not revoke the tokens")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lockedOut responds with 429 Too Many Requests when failed logins block the
+// username or the IP address, telling when to try again
+func (bm *BookManagerServer) lockedOut(w http.ResponseWriter, err error, username, ip string) bool {
+	var lockout *authenticate.LockoutError
+	if !errors.As(err, &lockout) {
+		return false
+	}
+	bm.Logger.WithField("ip", ip).Warn("a login of ", username, " is refused until ", lockout.Until)
+	tooManyAttempts(w, lockout.RetryAfter, err.Error())
+	return true
+}
+
+// tooManyAttempts responds with 429 Too Many Requests, telling to try again
+// after the given duration
+func tooManyAttempts(w http.ResponseWriter, retryAfter time.Duration, detail string) {
+	seconds := math.Ceil(retryAfter.Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(seconds, 1))))
+	writeProblem(w, http.StatusTooManyRequests, codeTooManyAttempts, detail)
+}
+
+func writeTokens(w http.ResponseWriter, token *authenticate.Token) {
 	response := map[string]interface{}{
-		"access_token": token.TokenString,
+		"access_token":  token.TokenString,
+		"expires_in":    int(token.ExpiresIn.Seconds()),
+		"refresh_token": token.RefreshToken,
 	}
 	resBody, _ := json.Marshal(response)
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(http.StatusOK)
 	w.Write(resBody)
 }
 
 func (bm *BookManagerServer) HandleSignUp(w http.ResponseWriter, r *http.Request) {
-	// Check Method
-	if r.Method != http.MethodPost {
-		bm.Logger.Warn("the signup api is not called by POST method")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
-
 	// Parse the request body for the new user
 	reqData, err := io.ReadAll(r.Body)
 	if err != nil {
-		bm.Logger.Warn("can not read the body of the request")
-		w.WriteHeader(http.StatusBadRequest)
+		bm.invalidBody(w, err)
 		return
 	}
 
 	var sr signupRequest
 	err = json.Unmarshal(reqData, &sr)
 	if err != nil {
-		bm.Logger.Warn("can not unmarshal the signup request body")
-		w.WriteHeader(http.StatusBadRequest)
+		bm.invalidBody(w, err)
+		return
+	}
+	if violations := sr.validate(bm.Passwords); len(violations) > 0 {
+		invalidFields(w, violations)
 		return
 	}
 
@@ -95,10 +193,18 @@ func (bm *BookManagerServer) HandleSignUp(w http.ResponseWriter, r *http.Request
 		PhoneNumber: sr.PhoneNumber,
 		Password:    sr.Password,
 	})
+	if errors.Is(err, db.ErrUsernameTaken) {
+		writeProblem(w, http.StatusConflict, codeUsernameTaken, err.Error(),
+			fieldError{Field: "username", Message: err.Error()})
+		return
+	}
+	if errors.Is(err, db.ErrPhoneNumberTaken) {
+		writeProblem(w, http.StatusConflict, codePhoneNumberTaken, err.Error(),
+			fieldError{Field: "phone_number", Message: err.Error()})
+		return
+	}
 	if err != nil {
-		bm.Logger.WithError(err).Warn("can not create new user")
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		bm.internalError(w, err, "can not create new user")
 		return
 	}
 
@@ -107,7 +213,7 @@ func (bm *BookManagerServer) HandleSignUp(w http.ResponseWriter, r *http.Request
 	}
 
 	resBody, _ := json.Marshal(response)
-	w.WriteHeader(http.StatusAccepted)
+	w.WriteHeader(http.StatusCreated)
 	w.Write(resBody)
 
 }
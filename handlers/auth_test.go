@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSignUpStatusCodes(t *testing.T) {
+	s := newTestServer(t)
+	body := `{"username": "alice", "password": "Correct-Horse-7"}`
+
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/signup", "", body), http.StatusCreated, "")
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/signup", "", body), http.StatusConflict, codeUsernameTaken)
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/signup", "", "{"), http.StatusBadRequest, codeInvalidBody)
+}
+
+func TestSignUpValidation(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.do(t, http.MethodPost, "/auth/signup", "", `{"username": "a b", "phone_number": "call me"}`)
+	expectStatus(t, w, http.StatusBadRequest, codeValidationFailed)
+	expectViolations(t, w, "username", "password", "phone_number")
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"bookman/db"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+type authorRequestResponse struct {
+	ID          uint   `json:"id"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	Birthday    string `json:"birthday"`
+	Nationality string `json:"nationality"`
+}
+
+func newAuthorResponse(author *db.Author) authorRequestResponse {
+	return authorRequestResponse{
+		ID:          author.ID,
+		FirstName:   author.FirstName,
+		LastName:    author.LastName,
+		Birthday:    author.Birthday,
+		Nationality: author.Nationality,
+	}
+}
+
+// authorRules are the rules of an author, either on its own or in a book
+// where the names of its fields start with the given prefix
+func authorRules(prefix, firstName, lastName, birthday, nationality string) []fieldRules {
+	return []fieldRules{
+		field(prefix+"first_name", firstName, maxLength(25)),
+		field(prefix+"last_name", lastName, maxLength(25)),
+		field(prefix+"birthday", birthday, date()),
+		field(prefix+"nationality", nationality, maxLength(25)),
+	}
+}
+
+// validate checks an author before it is stored. A new author must have a
+// name.
+func (ar *authorRequestResponse) validate(complete bool) []fieldError {
+	violations := validate(authorRules("", ar.FirstName, ar.LastName, ar.Birthday, ar.Nationality)...)
+	if complete && ar.FirstName == "" && ar.LastName == "" {
+		violations = append(violations, fieldError{Field: "last_name", Message: "either the first or the last name is required"})
+	}
+	return violations
+}
+
+func (bm *BookManagerServer) HandleAuthorsForPostMethod(w http.ResponseWriter, r *http.Request) {
+	if !bm.allow(w, userFromRequest(r), actionCreateAuthor) {
+		return
+	}
+
+	// Parse the request body for new author
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	var ar authorRequestResponse
+	err = json.Unmarshal(reqData, &ar)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+	if violations := ar.validate(true); len(violations) > 0 {
+		invalidFields(w, violations)
+		return
+	}
+
+	author := db.Author{
+		FirstName:   ar.FirstName,
+		LastName:    ar.LastName,
+		Birthday:    ar.Birthday,
+		Nationality: ar.Nationality,
+	}
+	err = bm.DB.CreateNewAuthor(&author)
+	if errors.Is(err, db.ErrAuthorExists) {
+		writeProblem(w, http.StatusConflict, codeAuthorExists, err.Error())
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not add new author")
+		return
+	}
+
+	resBody, _ := json.Marshal(newAuthorResponse(&author))
+	w.WriteHeader(http.StatusCreated)
+	w.Write(resBody)
+}
+
+func (bm *BookManagerServer) HandleAuthorsForGetMethod(w http.ResponseWriter, r *http.Request) {
+	if !bm.allow(w, userFromRequest(r), actionReadBooks) {
+		return
+	}
+
+	allAuthors, err := bm.DB.GetAllAuthors()
+	if err != nil {
+		bm.internalError(w, err, "can not retrieve all authors")
+		return
+	}
+
+	allAuthorsResponse := []authorRequestResponse{}
+	for i := range allAuthors {
+		allAuthorsResponse = append(allAuthorsResponse, newAuthorResponse(&allAuthors[i]))
+	}
+	response := map[string]interface{}{
+		"authors": allAuthorsResponse,
+	}
+
+	resBody, _ := json.Marshal(response)
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBody)
+}
+
+func (bm *BookManagerServer) HandleOneAuthorForGetMethod(w http.ResponseWriter, r *http.Request) {
+	if !bm.allow(w, userFromRequest(r), actionReadBooks) {
+		return
+	}
+
+	authorID := idFromPath(r)
+	author, err := bm.DB.GetAuthorByID(authorID)
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "the author does not exist")
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not retrieve author ", authorID)
+		return
+	}
+
+	resBody, _ := json.Marshal(newAuthorResponse(author))
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBody)
+}
+
+func (bm *BookManagerServer) HandleOneAuthorForPatchMethod(w http.ResponseWriter, r *http.Request) {
+	if !bm.allow(w, userFromRequest(r), actionEditAuthors) {
+		return
+	}
+
+	authorID := idFromPath(r)
+	// Parse the request body for the author with given ID
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	var ar authorRequestResponse
+	err = json.Unmarshal(reqData, &ar)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+	if violations := ar.validate(false); len(violations) > 0 {
+		invalidFields(w, violations)
+		return
+	}
+
+	updatedAuthor, err := bm.DB.UpdateAuthorByID(&db.Author{
+		FirstName:   ar.FirstName,
+		LastName:    ar.LastName,
+		Birthday:    ar.Birthday,
+		Nationality: ar.Nationality,
+	}, authorID)
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "the author does not exist")
+		return
+	}
+	if errors.Is(err, db.ErrAuthorExists) {
+		writeProblem(w, http.StatusConflict, codeAuthorExists, err.Error())
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not update author ", authorID)
+		return
+	}
+
+	resBody, _ := json.Marshal(newAuthorResponse(updatedAuthor))
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBody)
+}
+
+func (bm *BookManagerServer) HandleOneAuthorForDeleteMethod(w http.ResponseWriter, r *http.Request) {
+	if !bm.allow(w, userFromRequest(r), actionEditAuthors) {
+		return
+	}
+
+	authorID := idFromPath(r)
+	err := bm.DB.DeleteAuthorByID(authorID)
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "the author does not exist")
+		return
+	}
+	if errors.Is(err, db.ErrAuthorInUse) {
+		writeProblem(w, http.StatusConflict, codeAuthorInUse, err.Error())
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not delete author ", authorID)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"bookman/db"
+	"net/http"
+	"testing"
+)
+
+func TestAuthorPermissions(t *testing.T) {
+	s := newTestServer(t)
+	member := s.signup(t, "alice", db.RoleMember)
+	librarian := s.signup(t, "carol", db.RoleLibrarian)
+	reader := s.signup(t, "dave", db.RoleReadOnly)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+		code   string
+	}{
+		{"create as member", http.MethodPost, "/authors", member, `{"first_name": "Frank", "last_name": "Herbert"}`, http.StatusCreated, ""},
+		{"create as readonly", http.MethodPost, "/authors", reader, `{"first_name": "Ursula", "last_name": "Le Guin"}`, http.StatusForbidden, codeForbidden},
+		{"patch as member", http.MethodPatch, "/authors/1", member, `{"nationality": "US"}`, http.StatusForbidden, codeForbidden},
+		{"delete as member", http.MethodDelete, "/authors/1", member, "", http.StatusForbidden, codeForbidden},
+		{"patch as librarian", http.MethodPatch, "/authors/1", librarian, `{"nationality": "US"}`, http.StatusOK, ""},
+		{"delete as librarian", http.MethodDelete, "/authors/1", librarian, "", http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, tt.method, tt.path, tt.token, tt.body)
+			expectStatus(t, w, tt.status, tt.code)
+		})
+	}
+}
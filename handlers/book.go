@@ -3,53 +3,169 @@ package handlers
 import (
 	"bookman/db"
 	"encoding/json"
-	"github.com/gorilla/mux"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 )
 
 type authorInBook struct {
+	ID          uint   `json:"id"`
 	FirstName   string `json:"first_name"`
 	LastName    string `json:"last_name"`
 	Birthday    string `json:"birthday"`
 	Nationality string `json:"nationality"`
 }
 
+type contributorInBook struct {
+	AuthorID    uint   `json:"author_id"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	Birthday    string `json:"birthday"`
+	Nationality string `json:"nationality"`
+	Role        string `json:"role"`
+}
+
 type bookRequestResponse struct {
-	Name            string       `json:"name"`
-	Author          authorInBook `json:"author"`
-	Category        string       `json:"category"`
-	Volume          uint         `json:"volume"`
-	PublishedAt     string       `json:"published_at"`
-	Summary         string       `json:"summary"`
-	TableOfContents []string     `json:"table_of_contents"`
-	Publisher       string       `json:"publisher"`
+	ID              uint                `json:"id"`
+	CreatedAt       time.Time           `json:"created_at"`
+	UpdatedAt       time.Time           `json:"updated_at"`
+	CreatedBy       string              `json:"created_by"`
+	Name            string              `json:"name"`
+	AuthorID        uint                `json:"author_id,omitempty"`
+	Author          authorInBook        `json:"author"`
+	Contributors    []contributorInBook `json:"contributors"`
+	Category        string              `json:"category"`
+	Volume          uint                `json:"volume"`
+	PublishedAt     string              `json:"published_at"`
+	Summary         string              `json:"summary"`
+	TableOfContents []string            `json:"table_of_contents"`
+	Publisher       string              `json:"publisher"`
 }
 
-func HandleBooksForPostMethod(w http.ResponseWriter, r *http.Request,
-	bm *BookManagerServer, authorizedUser *string) {
-	//	Retrieve user from database
-	user, err := bm.DB.GetUserByUsername(*authorizedUser)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		bm.Logger.WithError(err).Warn("retrieving user from db: ")
+// newBookResponse converts a book loaded with its author, table of contents
+// and contributors
+func newBookResponse(book *db.Book) bookRequestResponse {
+	contents := []string{}
+	for _, content := range book.TableOfContents {
+		contents = append(contents, content.Item)
+	}
+	return bookRequestResponse{
+		ID:        book.ID,
+		CreatedAt: book.CreatedAt,
+		UpdatedAt: book.UpdatedAt,
+		CreatedBy: book.CreatedBy.Username,
+		Name:      book.Name,
+		Author: authorInBook{
+			ID:          book.Author.ID,
+			FirstName:   book.Author.FirstName,
+			LastName:    book.Author.LastName,
+			Birthday:    book.Author.Birthday,
+			Nationality: book.Author.Nationality,
+		},
+		Contributors:    newContributorsResponse(book.Contributors),
+		Volume:          book.Volume,
+		Category:        book.Category,
+		Summary:         book.Summary,
+		Publisher:       book.Publisher,
+		PublishedAt:     book.PublishedAt,
+		TableOfContents: contents,
+	}
+}
+
+// validate checks a book before it is stored. A complete book, as added or
+// replaced, must have a name and an author.
+func (br *bookRequestResponse) validate(complete bool) []fieldError {
+	rules := []fieldRules{
+		field("name", br.Name, requiredIf(complete), maxLength(25)),
+		field("category", br.Category, oneOf(db.BookCategories...)),
+		field("summary", br.Summary, maxLength(100)),
+		field("publisher", br.Publisher, maxLength(20)),
+		field("published_at", br.PublishedAt, date()),
+	}
+	rules = append(rules, authorRules("author.", br.Author.FirstName, br.Author.LastName, br.Author.Birthday, br.Author.Nationality)...)
+	for i, c := range br.Contributors {
+		prefix := fmt.Sprintf("contributors[%d].", i)
+		rules = append(rules, field(prefix+"role", c.Role, oneOf(db.ContributorAuthor, db.ContributorTranslator, db.ContributorEditor)))
+		rules = append(rules, authorRules(prefix, c.FirstName, c.LastName, c.Birthday, c.Nationality)...)
+	}
+	violations := validate(rules...)
+
+	if complete && br.AuthorID == 0 && br.Author.FirstName == "" && br.Author.LastName == "" {
+		violations = append(violations, fieldError{Field: "author", Message: "either author_id or the name of the author is required"})
+	}
+	for i, c := range br.Contributors {
+		if c.AuthorID == 0 && c.FirstName == "" && c.LastName == "" {
+			violations = append(violations, fieldError{
+				Field:   fmt.Sprintf("contributors[%d]", i),
+				Message: "either author_id or the name of the contributor is required",
+			})
+		}
+	}
+	return violations
+}
+
+// contributorsFromRequest converts the contributors of a request body, keeping
+// a missing list nil so updates can tell it apart from an empty one
+func contributorsFromRequest(contributors []contributorInBook) []db.BookContributor {
+	if contributors == nil {
+		return nil
+	}
+	result := make([]db.BookContributor, 0, len(contributors))
+	for _, c := range contributors {
+		result = append(result, db.BookContributor{
+			AuthorID: c.AuthorID,
+			Author: db.Author{
+				FirstName:   c.FirstName,
+				LastName:    c.LastName,
+				Birthday:    c.Birthday,
+				Nationality: c.Nationality,
+			},
+			Role: c.Role,
+		})
+	}
+	return result
+}
+
+func newContributorsResponse(contributors []db.BookContributor) []contributorInBook {
+	result := []contributorInBook{}
+	for _, c := range contributors {
+		result = append(result, contributorInBook{
+			AuthorID:    c.AuthorID,
+			FirstName:   c.Author.FirstName,
+			LastName:    c.Author.LastName,
+			Birthday:    c.Author.Birthday,
+			Nationality: c.Author.Nationality,
+			Role:        c.Role,
+		})
+	}
+	return result
+}
+
+func (bm *BookManagerServer) HandleBooksForPostMethod(w http.ResponseWriter, r *http.Request) {
+	user := userFromRequest(r)
+	if !bm.allow(w, user, actionCreateBook) {
 		return
 	}
 
 	// Parse the request body for new book
 	reqData, err := io.ReadAll(r.Body)
 	if err != nil {
-		bm.Logger.Warn("can not read the body of the request")
-		w.WriteHeader(http.StatusBadRequest)
+		bm.invalidBody(w, err)
 		return
 	}
 
 	var br bookRequestResponse
 	err = json.Unmarshal(reqData, &br)
 	if err != nil {
-		bm.Logger.Warn("can not unmarshal the add book request body")
-		w.WriteHeader(http.StatusBadRequest)
+		bm.invalidBody(w, err)
+		return
+	}
+	if violations := br.validate(true); len(violations) > 0 {
+		invalidFields(w, violations)
 		return
 	}
 
@@ -58,7 +174,7 @@ func HandleBooksForPostMethod(w http.ResponseWriter, r *http.Request,
 	for _, name := range br.TableOfContents {
 		contents = append(contents, db.TableOfContent{Item: name})
 	}
-	err = bm.DB.CreateNewBook(&db.Book{
+	newBook := db.Book{
 		Name:        br.Name,
 		CreatedBy:   *user,
 		Category:    br.Category,
@@ -66,6 +182,7 @@ func HandleBooksForPostMethod(w http.ResponseWriter, r *http.Request,
 		Publisher:   br.Publisher,
 		Summary:     br.Summary,
 		Volume:      br.Volume,
+		AuthorID:    br.AuthorID,
 		Author: db.Author{
 			FirstName:   br.Author.FirstName,
 			LastName:    br.Author.LastName,
@@ -73,241 +190,297 @@ func HandleBooksForPostMethod(w http.ResponseWriter, r *http.Request,
 			Nationality: br.Author.Nationality,
 		},
 		TableOfContents: contents,
-	})
+		Contributors:    contributorsFromRequest(br.Contributors),
+	}
+	err = bm.DB.CreateNewBook(&newBook)
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, http.StatusBadRequest, codeValidationFailed, "the author of the book does not exist",
+			fieldError{Field: "author_id", Message: "the author with given author_id does not exist"})
+		return
+	}
 	if err != nil {
-		bm.Logger.WithError(err).Warn("can not add new book")
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		bm.bookWriteError(w, err, "can not add new book")
 		return
 	}
 
-	response := map[string]interface{}{
-		"message": "book has been added successfully",
+	// Respond with the created book as it is stored
+	createdBook, err := bm.DB.GetABookByID(newBook.ID)
+	if err != nil {
+		bm.internalError(w, err, "can not retrieve the new book ", newBook.ID)
+		return
 	}
 
-	resBody, _ := json.Marshal(response)
-	w.WriteHeader(http.StatusAccepted)
+	resBody, _ := json.Marshal(newBookResponse(createdBook))
+	w.Header().Set("Location", fmt.Sprintf("/books/%d", createdBook.ID))
+	w.WriteHeader(http.StatusCreated)
 	w.Write(resBody)
 }
 
-func HandleBooksForGetMethod(w http.ResponseWriter, bm *BookManagerServer) {
-	//	Get all books of users
-	allBooks, err := bm.DB.GetAllBooks()
-	if err != nil {
-		bm.Logger.WithError(err).Warn("can not retrieve all books")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+// bookFilterFromQuery reads the filters and the order of a book listing from
+// the query string
+func bookFilterFromQuery(query url.Values) (db.BookFilter, error) {
+	filter := db.BookFilter{
+		Name:            query.Get("name"),
+		Author:          query.Get("author"),
+		Contributor:     query.Get("contributor"),
+		ContributorRole: query.Get("role"),
+		Category:        query.Get("category"),
+		Publisher:       query.Get("publisher"),
+		PublishedFrom:   query.Get("published_from"),
+		PublishedTo:     query.Get("published_to"),
+		Search:          query.Get("q"),
+		Sort:            query.Get("sort"),
+	}
+	if filter.ContributorRole != "" && !db.IsValidContributorRole(filter.ContributorRole) {
+		return filter, fieldError{Field: "role", Message: db.ErrInvalidContributorRole.Error()}
+	}
+	// dates are compared as strings, so only complete dates order correctly
+	violations := validate(
+		field("published_from", filter.PublishedFrom, date()),
+		field("published_to", filter.PublishedTo, date()),
+	)
+	if len(violations) > 0 {
+		return filter, violations[0]
+	}
+	if volume := query.Get("volume"); volume != "" {
+		v, err := strconv.ParseUint(volume, 10, 64)
+		if err != nil {
+			return filter, fieldError{Field: "volume", Message: "the volume must be a positive number"}
+		}
+		filter.Volume = uint(v)
+	}
+	return filter, nil
+}
+
+func (bm *BookManagerServer) HandleBooksForGetMethod(w http.ResponseWriter, r *http.Request) {
+	if !bm.allow(w, userFromRequest(r), actionReadBooks) {
 		return
 	}
-	// Marshal all books
-	var allBooksResponse []bookRequestResponse
-	for _, book := range allBooks {
 
-		// get items of table of contents for each book
-		contents, err := bm.DB.GetContentsByBookID(book.ID)
-		if err != nil {
-			bm.Logger.WithError(err).Warn("can not retrieve contents of book ", book.Name)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
-		}
+	filter, err := bookFilterFromQuery(r.URL.Query())
+	if err != nil {
+		invalidQuery(w, err)
+		return
+	}
 
-		//	Get author of each book
-		author, err := bm.DB.GetAuthorByID(book.AuthorID)
-		if err != nil {
-			bm.Logger.WithError(err).Warn("can not retrieve author of book ", book.Name)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
-		}
+	page, err := pageFromQuery(r.URL.Query())
+	if err != nil {
+		invalidQuery(w, err)
+		return
+	}
 
-		// Add books to list of all books in structure of bookRequestResponse
-		allBooksResponse = append(allBooksResponse,
-			bookRequestResponse{
-				Name: book.Name,
-				Author: authorInBook{
-					FirstName:   author.FirstName,
-					LastName:    author.LastName,
-					Nationality: author.Nationality,
-					Birthday:    author.Birthday,
-				},
-				PublishedAt:     book.PublishedAt,
-				Publisher:       book.Publisher,
-				Summary:         book.Summary,
-				Category:        book.Category,
-				Volume:          book.Volume,
-				TableOfContents: contents,
-			})
+	//	Get one page of the books of users matching the filters
+	bookPage, err := bm.DB.FindBooks(filter, page)
+	if errors.Is(err, db.ErrInvalidSort) {
+		invalidQuery(w, fieldError{Field: "sort", Message: err.Error()})
+		return
+	}
+	if errors.Is(err, db.ErrInvalidCursor) {
+		invalidQuery(w, err)
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not retrieve all books")
+		return
+	}
+	// Marshal all books
+	allBooksResponse := []bookRequestResponse{}
+	for i := range bookPage.Books {
+		allBooksResponse = append(allBooksResponse, newBookResponse(&bookPage.Books[i]))
 	}
 	response := map[string]interface{}{
-		"books": allBooksResponse,
+		"books":    allBooksResponse,
+		"total":    bookPage.Total,
+		"limit":    page.Limit,
+		"next":     nil,
+		"previous": nil,
+	}
+
+	// Link the neighbouring pages the same way this page was requested
+	cursorMode := page.After != "" || page.Before != ""
+	if !cursorMode {
+		response["offset"] = page.Offset
+	}
+	if bookPage.HasNext {
+		response["next_cursor"] = bookPage.NextCursor
+		if cursorMode {
+			response["next"] = pageLink(r, map[string]string{"after": bookPage.NextCursor})
+		} else {
+			response["next"] = pageLink(r, map[string]string{"offset": strconv.Itoa(page.Offset + page.Limit)})
+		}
+	}
+	if bookPage.HasPrev {
+		response["previous_cursor"] = bookPage.PrevCursor
+		if cursorMode {
+			response["previous"] = pageLink(r, map[string]string{"before": bookPage.PrevCursor})
+		} else {
+			previousOffset := page.Offset - page.Limit
+			if previousOffset < 0 {
+				previousOffset = 0
+			}
+			response["previous"] = pageLink(r, map[string]string{"offset": strconv.Itoa(previousOffset)})
+		}
 	}
 
 	resBody, _ := json.Marshal(response)
-	w.WriteHeader(http.StatusAccepted)
+	w.WriteHeader(http.StatusOK)
 	w.Write(resBody)
 
 }
 
-func (bm *BookManagerServer) HandleBooks(w http.ResponseWriter, r *http.Request) {
-	//	Grab Authorization header
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		bm.Logger.Warn("token empty")
-		return
-	}
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
 
-	//	Retrieve the related account by token
-	accountUsername, err := bm.Authenticate.GetAccountByToken(token)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		bm.Logger.WithError(err).Warn("retrieving account: ")
-		return
+// pageFromQuery reads the limit and either the offset or the cursor of a page
+func pageFromQuery(query url.Values) (db.Page, error) {
+	page := db.Page{
+		Limit:  defaultPageLimit,
+		After:  query.Get("after"),
+		Before: query.Get("before"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxPageLimit {
+			return page, fieldError{Field: "limit", Message: fmt.Sprintf("the limit must be between 1 and %d", maxPageLimit)}
+		}
+		page.Limit = l
+	}
+	if offset := query.Get("offset"); offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil || o < 0 {
+			return page, fieldError{Field: "offset", Message: "the offset must not be negative"}
+		}
+		page.Offset = o
 	}
+	if page.After != "" && page.Before != "" {
+		return page, errors.New("only one of after and before can be given")
+	}
+	if page.Offset != 0 && (page.After != "" || page.Before != "") {
+		return page, errors.New("the offset can not be combined with a cursor")
+	}
+	return page, nil
+}
 
-	// Check Method POST -> add new book, GET -> returns all book
-	if r.Method == http.MethodPost {
-		HandleBooksForPostMethod(w, r, bm, accountUsername)
-	} else if r.Method == http.MethodGet {
-		HandleBooksForGetMethod(w, bm)
-	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		bm.Logger.Warn("Method is neither POST nor GET")
-		return
+// pageLink returns the URL of the current request with its pagination
+// parameters replaced by the given ones
+func pageLink(r *http.Request, params map[string]string) string {
+	query := r.URL.Query()
+	for _, key := range []string{"offset", "after", "before"} {
+		query.Del(key)
+	}
+	for key, value := range params {
+		query.Set(key, value)
 	}
+	return r.URL.Path + "?" + query.Encode()
 }
 
-func HandleOneBookForGetMethod(bm *BookManagerServer, w http.ResponseWriter, r *http.Request, bookID uint) {
-	book, err := bm.DB.GetABookByID(bookID)
-	if err != nil {
-		bm.Logger.WithError(err).Warn("can not retrieve book ", bookID)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+func (bm *BookManagerServer) HandleOneBookForGetMethod(w http.ResponseWriter, r *http.Request) {
+	if !bm.allow(w, userFromRequest(r), actionReadBooks) {
 		return
 	}
-	// get items of table of contents for each book
-	contents, err := bm.DB.GetContentsByBookID(book.ID)
-	if err != nil {
-		bm.Logger.WithError(err).Warn("can not retrieve contents of book ", book.Name)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+
+	bookID := idFromPath(r)
+	book, err := bm.DB.GetABookByID(bookID)
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "the book does not exist")
 		return
 	}
-
-	//	Get author of book
-	author, err := bm.DB.GetAuthorByID(book.AuthorID)
 	if err != nil {
-		bm.Logger.WithError(err).Warn("can not retrieve author of book ", book.Name)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		bm.internalError(w, err, "can not retrieve book ", bookID)
 		return
 	}
-
-	bookResponse := bookRequestResponse{
-		Name: book.Name,
-		Author: authorInBook{
-			FirstName:   author.FirstName,
-			LastName:    author.LastName,
-			Birthday:    author.Birthday,
-			Nationality: author.Nationality,
-		},
-		Volume:          book.Volume,
-		Category:        book.Category,
-		Summary:         book.Summary,
-		Publisher:       book.Publisher,
-		PublishedAt:     book.PublishedAt,
-		TableOfContents: contents,
-	}
+	bookResponse := newBookResponse(book)
 
 	resBody, err := json.Marshal(bookResponse)
 	if err != nil {
-		bm.Logger.WithError(err).Warn("can not marshal retrieved book to json", book.Name)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		bm.internalError(w, err, "can not marshal retrieved book to json ", book.Name)
 		return
 	}
-	w.WriteHeader(http.StatusAccepted)
+	w.WriteHeader(http.StatusOK)
 	w.Write(resBody)
 }
 
-func HandleOneBookForDeleteMethod(
-	bm *BookManagerServer,
-	w http.ResponseWriter,
-	r *http.Request,
-	loginUsername *string,
-	bookID uint) {
+func (bm *BookManagerServer) HandleOneBookForDeleteMethod(w http.ResponseWriter, r *http.Request) {
+	user := userFromRequest(r)
+	bookID := idFromPath(r)
 
-	//	Check if login user is the one who created the book with given ID
+	// Check if there is an error for finding the username of given book
 	usernameBook, err := bm.DB.GetCreatedByUsernameByID(bookID)
-	if *usernameBook != *loginUsername {
-		bm.Logger.WithError(err).Warn("you didn't add the book with given ID in URL")
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(err.Error()))
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "the book does not exist")
 		return
 	}
-
-	// Check if there is an error for finding the username of given book
 	if err != nil {
-		bm.Logger.WithError(err).Warn("can not retrieve the book with given ID ")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		bm.internalError(w, err, "can not retrieve the book with given ID ", bookID)
 		return
 	}
 
-	if err = bm.DB.DeleteBookByID(bookID); err != nil {
-		bm.Logger.WithError(err).Warn("can not delete the book with given ID ")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+	//	Check if login user created the book with given ID or may delete any book
+	if !canModifyBook(user, *usernameBook, actionDeleteOwnBook, actionDeleteAnyBook) {
+		bm.Logger.Warn("you didn't add the book with given ID in URL")
+		writeProblem(w, http.StatusForbidden, codeForbidden, "you are not allowed to delete this book")
 		return
 	}
 
-	response := map[string]interface{}{
-		"message": "book has been deleted successfully",
+	if err = bm.DB.DeleteBookByID(bookID); err != nil {
+		bm.internalError(w, err, "can not delete the book with given ID ", bookID)
+		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	resBody, _ := json.Marshal(response)
-	w.WriteHeader(http.StatusAccepted)
-	w.Write(resBody)
+// HandleOneBookForPatchMethod changes the given fields of the book
+func (bm *BookManagerServer) HandleOneBookForPatchMethod(w http.ResponseWriter, r *http.Request) {
+	bm.updateBook(w, r, false)
 }
 
-func HandleOneBookForPatchMethod(
-	bm *BookManagerServer,
-	w http.ResponseWriter,
-	r *http.Request,
-	loginUsername *string,
-	bookID uint) {
+// HandleOneBookForPutMethod replaces the book with the given one, clearing
+// the fields which are not given
+func (bm *BookManagerServer) HandleOneBookForPutMethod(w http.ResponseWriter, r *http.Request) {
+	bm.updateBook(w, r, true)
+}
 
-	//	Check if login user is the one who created the book with given ID
-	usernameBook, err := bm.DB.GetCreatedByUsernameByID(bookID)
-	if *usernameBook != *loginUsername {
-		bm.Logger.WithError(err).Warn("you didn't add the book with given ID in URL")
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(err.Error()))
+func (bm *BookManagerServer) updateBook(w http.ResponseWriter, r *http.Request, replace bool) {
+	user := userFromRequest(r)
+	bookID := idFromPath(r)
+
+	// Parse the request body for the book with given ID
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
 		return
 	}
 
-	// Check if there is an error for finding the username of given book
+	var br bookRequestResponse
+	err = json.Unmarshal(reqData, &br)
 	if err != nil {
-		bm.Logger.WithError(err).Warn("can not retrieve the book with given ID ")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		bm.invalidBody(w, err)
 		return
 	}
 
-	// Parse the request body for the book with given ID
-	reqData, err := io.ReadAll(r.Body)
-	if err != nil {
-		bm.Logger.Warn("can not read the body of the request")
-		w.WriteHeader(http.StatusBadRequest)
+	// a replacement must be a complete book
+	if violations := br.validate(replace); len(violations) > 0 {
+		invalidFields(w, violations)
 		return
 	}
 
-	var br bookRequestResponse
-	err = json.Unmarshal(reqData, &br)
+	// Check if there is an error for finding the username of given book
+	usernameBook, err := bm.DB.GetCreatedByUsernameByID(bookID)
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "the book does not exist")
+		return
+	}
 	if err != nil {
-		bm.Logger.Warn("can not unmarshal the update book request body")
-		w.WriteHeader(http.StatusBadRequest)
+		bm.internalError(w, err, "can not retrieve the book with given ID ", bookID)
+		return
+	}
+
+	//	Check if login user created the book with given ID or may edit any book
+	if !canModifyBook(user, *usernameBook, actionEditOwnBook, actionEditAnyBook) {
+		bm.Logger.Warn("you didn't add the book with given ID in URL")
+		writeProblem(w, http.StatusForbidden, codeForbidden, "you are not allowed to edit this book")
 		return
 	}
 
@@ -316,13 +489,18 @@ func HandleOneBookForPatchMethod(
 	for _, name := range br.TableOfContents {
 		contents = append(contents, db.TableOfContent{Item: name})
 	}
-	updatedBook, err := bm.DB.UpdateBookByID(&db.Book{
+	update := bm.DB.UpdateBookByID
+	if replace {
+		update = bm.DB.ReplaceBookByID
+	}
+	updatedBook, err := update(&db.Book{
 		Name:        br.Name,
 		Category:    br.Category,
 		PublishedAt: br.PublishedAt,
 		Publisher:   br.Publisher,
 		Summary:     br.Summary,
 		Volume:      br.Volume,
+		AuthorID:    br.AuthorID,
 		Author: db.Author{
 			FirstName:   br.Author.FirstName,
 			LastName:    br.Author.LastName,
@@ -330,101 +508,49 @@ func HandleOneBookForPatchMethod(
 			Nationality: br.Author.Nationality,
 		},
 		TableOfContents: contents,
+		Contributors:    contributorsFromRequest(br.Contributors),
 	}, bookID)
-	if err != nil {
-		bm.Logger.WithError(err).Warn("can not add new book")
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, http.StatusBadRequest, codeValidationFailed, "the author of the book does not exist",
+			fieldError{Field: "author_id", Message: "the author with given author_id does not exist"})
 		return
 	}
-
-	// get items of table of contents for each book
-	updatedContents, err := bm.DB.GetContentsByBookID(updatedBook.ID)
 	if err != nil {
-		bm.Logger.WithError(err).Warn("can not retrieve contents of book ", updatedBook.Name)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		bm.bookWriteError(w, err, "can not update book ", bookID)
 		return
 	}
 
-	//	Get author of book
-	author, err := bm.DB.GetAuthorByID(updatedBook.AuthorID)
+	// Read the book back with its author, contents and contributors
+	updatedBook, err = bm.DB.GetABookByID(updatedBook.ID)
 	if err != nil {
-		bm.Logger.WithError(err).Warn("can not retrieve author of book ", updatedBook.Name)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		bm.internalError(w, err, "can not retrieve the updated book ", bookID)
 		return
 	}
-
-	bookResponse := bookRequestResponse{
-		Name: updatedBook.Name,
-		Author: authorInBook{
-			FirstName:   author.FirstName,
-			LastName:    author.LastName,
-			Birthday:    author.Birthday,
-			Nationality: author.Nationality,
-		},
-		Volume:          updatedBook.Volume,
-		Category:        updatedBook.Category,
-		Summary:         updatedBook.Summary,
-		Publisher:       updatedBook.Publisher,
-		PublishedAt:     updatedBook.PublishedAt,
-		TableOfContents: updatedContents,
-	}
+	bookResponse := newBookResponse(updatedBook)
 
 	resBody, err := json.Marshal(bookResponse)
 	if err != nil {
-		bm.Logger.WithError(err).Warn("can not marshal retrieved book to json", updatedBook.Name)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		bm.internalError(w, err, "can not marshal retrieved book to json ", updatedBook.Name)
 		return
 	}
-	w.WriteHeader(http.StatusAccepted)
+	w.WriteHeader(http.StatusOK)
 	w.Write(resBody)
 }
 
-func (bm *BookManagerServer) HandleOneBook(w http.ResponseWriter, r *http.Request) {
-
-	//	Grab Authorization header
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		bm.Logger.Warn("token empty")
-		return
-	}
-
-	//	Retrieve the username of user which is login
-	loginUsername, err := bm.Authenticate.GetAccountByToken(token)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		bm.Logger.WithError(err).Warn("retrieving account: ")
-		return
-	}
-
-	//	Check value of given id
-	pathID := mux.Vars(r)["id"]
-	bookID, err := strconv.ParseUint(pathID, 10, 64)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		bm.Logger.WithError(err).Warn("can not convert id to uint ")
-		return
+// bookWriteError responds to the errors of adding or changing a book which
+// the client can correct, and with 500 Internal Server Error to the others
+func (bm *BookManagerServer) bookWriteError(w http.ResponseWriter, err error, msg string, args ...interface{}) {
+	switch {
+	case errors.Is(err, db.ErrBookExists):
+		writeProblem(w, http.StatusConflict, codeBookExists, err.Error(),
+			fieldError{Field: "name", Message: err.Error()})
+	case errors.Is(err, db.ErrInvalidContributorRole):
+		writeProblem(w, http.StatusBadRequest, codeValidationFailed, err.Error(),
+			fieldError{Field: "contributors", Message: err.Error()})
+	case errors.Is(err, db.ErrBookWithoutAuthor):
+		writeProblem(w, http.StatusBadRequest, codeValidationFailed, err.Error(),
+			fieldError{Field: "author", Message: err.Error()})
+	default:
+		bm.internalError(w, err, msg, args...)
 	}
-
-	//	Check Method
-	//	DELETE -> delete the given book,
-	//	GET -> details of the given book
-	//	PUT -> update details of the given book
-	if r.Method == http.MethodGet {
-		HandleOneBookForGetMethod(bm, w, r, uint(bookID))
-	} else if r.Method == http.MethodDelete {
-		HandleOneBookForDeleteMethod(bm, w, r, loginUsername, uint(bookID))
-	} else if r.Method == http.MethodPatch {
-		HandleOneBookForPatchMethod(bm, w, r, loginUsername, uint(bookID))
-
-	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		bm.Logger.Warn("Method is not each of PUT, GET or DELETE")
-		return
-	}
-
 }
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bookman/db"
+	"net/http"
+	"testing"
+)
+
+const testBook = `{"name": "Dune", "author": {"first_name": "Frank", "last_name": "Herbert"}}`
+
+func TestBookStatusCodes(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup(t, "alice", db.RoleMember)
+	bob := s.signup(t, "bob", db.RoleMember)
+	librarian := s.signup(t, "carol", db.RoleLibrarian)
+	reader := s.signup(t, "dave", db.RoleReadOnly)
+
+	w := s.do(t, http.MethodPost, "/books", alice, testBook)
+	expectStatus(t, w, http.StatusCreated, "")
+	if location := w.Header().Get("Location"); location != "/books/1" {
+		t.Fatalf("expected the location /books/1, got %q", location)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+		code   string
+	}{
+		{"list", http.MethodGet, "/books", bob, "", http.StatusOK, ""},
+		{"list without token", http.MethodGet, "/books", "", "", http.StatusUnauthorized, codeUnauthorized},
+		{"list with invalid query", http.MethodGet, "/books?limit=0", bob, "", http.StatusBadRequest, codeInvalidQuery},
+		{"list with invalid date", http.MethodGet, "/books?published_from=1999", bob, "", http.StatusBadRequest, codeInvalidQuery},
+		{"get", http.MethodGet, "/books/1", bob, "", http.StatusOK, ""},
+		{"get missing", http.MethodGet, "/books/99", bob, "", http.StatusNotFound, codeNotFound},
+		{"create duplicate", http.MethodPost, "/books", bob, testBook, http.StatusConflict, codeBookExists},
+		{"create invalid body", http.MethodPost, "/books", bob, "{", http.StatusBadRequest, codeInvalidBody},
+		{"create as readonly", http.MethodPost, "/books", reader, testBook, http.StatusForbidden, codeForbidden},
+		{"patch missing", http.MethodPatch, "/books/99", alice, `{"volume": 2}`, http.StatusNotFound, codeNotFound},
+		{"patch as non-owner", http.MethodPatch, "/books/1", bob, `{"volume": 2}`, http.StatusForbidden, codeForbidden},
+		{"patch as owner", http.MethodPatch, "/books/1", alice, `{"volume": 2}`, http.StatusOK, ""},
+		{"patch as librarian", http.MethodPatch, "/books/1", librarian, `{"volume": 3}`, http.StatusOK, ""},
+		{"put missing", http.MethodPut, "/books/99", alice, testBook, http.StatusNotFound, codeNotFound},
+		{"put as non-owner", http.MethodPut, "/books/1", bob, testBook, http.StatusForbidden, codeForbidden},
+		{"put incomplete", http.MethodPut, "/books/1", alice, `{"name": "Dune"}`, http.StatusBadRequest, codeValidationFailed},
+		{"put as owner", http.MethodPut, "/books/1", alice, testBook, http.StatusOK, ""},
+		{"unsupported method", http.MethodPost, "/books/1", alice, "", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"delete missing", http.MethodDelete, "/books/99", alice, "", http.StatusNotFound, codeNotFound},
+		{"delete as non-owner", http.MethodDelete, "/books/1", bob, "", http.StatusForbidden, codeForbidden},
+		{"delete as librarian", http.MethodDelete, "/books/1", librarian, "", http.StatusForbidden, codeForbidden},
+		{"delete as owner", http.MethodDelete, "/books/1", alice, "", http.StatusNoContent, ""},
+		{"get deleted", http.MethodGet, "/books/1", alice, "", http.StatusNotFound, codeNotFound},
+		{"delete deleted", http.MethodDelete, "/books/1", alice, "", http.StatusNotFound, codeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, tt.method, tt.path, tt.token, tt.body)
+			expectStatus(t, w, tt.status, tt.code)
+		})
+	}
+}
+
+func TestBookValidation(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup(t, "alice", db.RoleMember)
+
+	w := s.do(t, http.MethodPost, "/books", alice, `{
+		"name": "A name much longer than twenty five characters",
+		"category": "cooking",
+		"published_at": "last year",
+		"contributors": [{"first_name": "Jane", "role": "illustrator"}]
+	}`)
+	expectStatus(t, w, http.StatusBadRequest, codeValidationFailed)
+	expectViolations(t, w, "name", "category", "published_at", "contributors[0].role", "author")
+
+	w = s.do(t, http.MethodPatch, "/books/1", alice, `{"published_at": "2023-02-30"}`)
+	expectStatus(t, w, http.StatusBadRequest, codeValidationFailed)
+	expectViolations(t, w, "published_at")
+}
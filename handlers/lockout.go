@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type lockoutResponse struct {
+	Username    string    `json:"username,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// clientIP is the address the failed logins of a request are counted for.
+// Behind a reverse proxy it is the last address of X-Forwarded-For, which
+// the proxy appended, as the ones before may be made up by the client.
+func (bm *BookManagerServer) clientIP(r *http.Request) string {
+	if bm.TrustForwardedFor {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// HandleLockoutsForGetMethod lists the usernames and the IP addresses whose
+// failed logins block them now
+func (bm *BookManagerServer) HandleLockoutsForGetMethod(w http.ResponseWriter, r *http.Request) {
+	//	The user authenticated by the access token
+	user := userFromRequest(r)
+	if !bm.allow(w, user, actionManageUsers) {
+		return
+	}
+
+	lockouts, err := bm.Authenticate.Lockouts()
+	if err != nil {
+		bm.internalError(w, err, "can not list the lockouts")
+		return
+	}
+
+	response := []lockoutResponse{}
+	for _, l := range lockouts {
+		response = append(response, lockoutResponse{
+			Username:    l.Username,
+			IP:          l.IP,
+			Failures:    l.Failures,
+			LockedUntil: l.Until,
+		})
+	}
+	resBody, _ := json.Marshal(response)
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBody)
+}
+
+// HandleLockoutsForDeleteMethod lets admins forget the failed logins of the
+// username or the IP address given in the query
+func (bm *BookManagerServer) HandleLockoutsForDeleteMethod(w http.ResponseWriter, r *http.Request) {
+	//	The user authenticated by the access token
+	user := userFromRequest(r)
+	if !bm.allow(w, user, actionManageUsers) {
+		return
+	}
+
+	username := strings.TrimSpace(r.URL.Query().Get("username"))
+	ip := strings.TrimSpace(r.URL.Query().Get("ip"))
+	if username == "" && ip == "" {
+		invalidQuery(w, fieldError{Field: "username", Message: "a username or an ip is required"})
+		return
+	}
+
+	if err := bm.Authenticate.ClearLockout(username, ip); err != nil {
+		bm.internalError(w, err, "can not clear the lockout of ", username, ip)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
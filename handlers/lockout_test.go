@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"bookman/authenticate"
+	"bookman/db"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoginLockout(t *testing.T) {
+	s := newTestServer(t)
+	s.bm.Authenticate.LimitLogins(authenticate.LoginLimits{
+		MaxFailures:   2,
+		FailureWindow: time.Hour,
+		Lockout:       time.Hour,
+	})
+	admin := s.signup(t, "admin", db.RoleAdmin)
+	member := s.signup(t, "alice", db.RoleMember)
+
+	wrong := `{"username": "alice", "password": "wrong"}`
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/login", "", `{"username": "nobody", "password": "wrong"}`),
+		http.StatusUnauthorized, codeInvalidCredentials)
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/login", "", wrong), http.StatusUnauthorized, codeInvalidCredentials)
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/login", "", wrong), http.StatusUnauthorized, codeInvalidCredentials)
+
+	right := `{"username": "alice", "password": "password"}`
+	w := s.do(t, http.MethodPost, "/auth/login", "", right)
+	expectStatus(t, w, http.StatusTooManyRequests, codeTooManyAttempts)
+	if seconds, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || seconds < 3590 || seconds > 3600 {
+		t.Fatalf("unexpected Retry-After %q", w.Header().Get("Retry-After"))
+	}
+
+	// only admins see and clear lockouts
+	expectStatus(t, s.do(t, http.MethodGet, "/admin/lockouts", member, ""), http.StatusForbidden, codeForbidden)
+	expectStatus(t, s.do(t, http.MethodDelete, "/admin/lockouts?username=alice", member, ""), http.StatusForbidden, codeForbidden)
+
+	w = s.do(t, http.MethodGet, "/admin/lockouts", admin, "")
+	expectStatus(t, w, http.StatusOK, "")
+	var lockouts []lockoutResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &lockouts); err != nil {
+		t.Fatal(err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Username != "alice" || lockouts[0].Failures != 2 {
+		t.Fatalf("unexpected lockouts %+v", lockouts)
+	}
+
+	expectStatus(t, s.do(t, http.MethodDelete, "/admin/lockouts", admin, ""), http.StatusBadRequest, codeInvalidQuery)
+	expectStatus(t, s.do(t, http.MethodDelete, "/admin/lockouts?username=alice", admin, ""), http.StatusNoContent, "")
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/login", "", right), http.StatusOK, "")
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+	r.RemoteAddr = "203.0.113.9:51234"
+	r.Header.Add("X-Forwarded-For", "192.0.2.1, 198.51.100.2")
+
+	bm := &BookManagerServer{}
+	if ip := bm.clientIP(r); ip != "203.0.113.9" {
+		t.Errorf("got %s without trusting the proxy, want the connection address", ip)
+	}
+	bm.TrustForwardedFor = true
+	if ip := bm.clientIP(r); ip != "198.51.100.2" {
+		t.Errorf("got %s behind the proxy, want the address it appended", ip)
+	}
+}
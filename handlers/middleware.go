@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bookman/db"
+	"context"
+	"net/http"
+	"strings"
+)
+
+type contextKey int
+
+const userContextKey contextKey = iota
+
+// Authenticated lets through the requests carrying a valid access token, with
+// the user of the token stored in the request context
+func (bm *BookManagerServer) Authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//	Grab Authorization header
+		token := bearerToken(r)
+		if token == "" {
+			bm.Logger.Warn("token empty")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, http.StatusUnauthorized, codeUnauthorized, "an access token is required")
+			return
+		}
+
+		//	Retrieve the related user by token
+		user, err := bm.Authenticate.GetAccountByToken(token)
+		if err != nil {
+			bm.Logger.WithError(err).Warn("retrieving account: ")
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeProblem(w, http.StatusUnauthorized, codeInvalidToken, "the access token is not valid")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// bearerToken returns the token of the Authorization header. Tokens sent
+// without the Bearer scheme are still accepted for older clients.
+func bearerToken(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	scheme, token, found := strings.Cut(header, " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return header
+}
+
+// userFromRequest returns the user stored by Authenticated
+func userFromRequest(r *http.Request) *db.User {
+	user, _ := r.Context().Value(userContextKey).(*db.User)
+	return user
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"bookman/authenticate"
+	"bookman/db"
+	"bookman/notify"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type passwordResetRequest struct {
+	Username string `json:"username"`
+}
+
+type passwordResetConfirmRequest struct {
+	Username    string `json:"username"`
+	Code        string `json:"code"`
+	NewPassword string `json:"new_password"`
+}
+
+// HandleChangePassword sets a new password of the authenticated user, who
+// has to give the old one. The other sessions of the user end.
+func (bm *BookManagerServer) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	//	The user authenticated by the access token
+	user := userFromRequest(r)
+
+	// Parse the request body for the passwords
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	var cr changePasswordRequest
+	err = json.Unmarshal(reqData, &cr)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+	violations := validate(
+		field("old_password", cr.OldPassword, required()),
+		field("new_password", cr.NewPassword, required(), passwordPolicy(bm.Passwords, user.Username)),
+	)
+	if len(violations) > 0 {
+		invalidFields(w, violations)
+		return
+	}
+
+	ip := bm.clientIP(r)
+	token, err := bm.Authenticate.ChangePassword(user, cr.OldPassword, cr.NewPassword, ip)
+	if bm.lockedOut(w, err, user.Username, ip) {
+		return
+	}
+	if errors.Is(err, authenticate.ErrWrongPassword) {
+		writeProblem(w, http.StatusForbidden, codeWrongPassword, err.Error(),
+			fieldError{Field: "old_password", Message: err.Error()})
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not change the password of user ", user.ID)
+		return
+	}
+
+	writeTokens(w, token)
+}
+
+// HandlePasswordResetRequest sends a password reset code to the user. The
+// response is the same whether the user exists or not, so it can not be used
+// to find out usernames, and the code is issued and sent in the background so
+// the response takes as long either way.
+func (bm *BookManagerServer) HandlePasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	if bm.resetsUnavailable(w) {
+		return
+	}
+
+	// Parse the request body for the username
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	var pr passwordResetRequest
+	err = json.Unmarshal(reqData, &pr)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+	if violations := validate(field("username", pr.Username, required())); len(violations) > 0 {
+		invalidFields(w, violations)
+		return
+	}
+
+	// Throttle the requests of the username and of the address, whether the
+	// user exists or not
+	ip := bm.clientIP(r)
+	err = bm.Authenticate.ThrottlePasswordReset(pr.Username, ip)
+	var throttled *authenticate.ResetThrottledError
+	if errors.As(err, &throttled) {
+		bm.Logger.WithField("ip", ip).Warn("a password reset of ", pr.Username, " is refused until ", throttled.Until)
+		tooManyAttempts(w, throttled.RetryAfter, err.Error())
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not count the password reset requests of ", pr.Username)
+		return
+	}
+
+	bm.background.Add(1)
+	go func() {
+		defer bm.background.Done()
+		bm.sendPasswordReset(pr.Username)
+	}()
+
+	response := map[string]interface{}{
+		"message": "if the user exists, a password reset code has been sent",
+	}
+
+	resBody, _ := json.Marshal(response)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(resBody)
+}
+
+// resetsUnavailable answers 503 when no notifier is configured to send the
+// reset codes
+func (bm *BookManagerServer) resetsUnavailable(w http.ResponseWriter) bool {
+	if bm.Notifier != nil {
+		return false
+	}
+	writeProblem(w, http.StatusServiceUnavailable, codeResetUnavailable, "password resets are not available on this server")
+	return true
+}
+
+// sendPasswordReset issues a reset code for the user and sends it
+func (bm *BookManagerServer) sendPasswordReset(username string) {
+	user, code, err := bm.Authenticate.RequestPasswordReset(username)
+	if errors.Is(err, db.ErrNotFound) {
+		bm.Logger.WithField("username", username).Warn("a password reset is requested for an unknown user")
+		return
+	}
+	if err != nil {
+		bm.Logger.WithError(err).Warn("can not issue a password reset code")
+		return
+	}
+	err = bm.Notifier.Notify(user, notify.Message{
+		Subject: "Password reset",
+		Body:    "Use this code to choose a new password: " + code,
+	})
+	if err != nil {
+		bm.Logger.WithError(err).Warn("can not send the password reset code to user ", user.ID)
+	}
+}
+
+// HandlePasswordReset sets a new password of the user with a reset code. The
+// sessions of the user end.
+func (bm *BookManagerServer) HandlePasswordReset(w http.ResponseWriter, r *http.Request) {
+	if bm.resetsUnavailable(w) {
+		return
+	}
+
+	// Parse the request body for the code and the new password
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	var pr passwordResetConfirmRequest
+	err = json.Unmarshal(reqData, &pr)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+	violations := validate(
+		field("username", pr.Username, required()),
+		field("code", pr.Code, required()),
+		field("new_password", pr.NewPassword, required(), passwordPolicy(bm.Passwords, pr.Username)),
+	)
+	if len(violations) > 0 {
+		invalidFields(w, violations)
+		return
+	}
+
+	err = bm.Authenticate.ResetPassword(pr.Username, pr.Code, pr.NewPassword)
+	if errors.Is(err, authenticate.ErrInvalidResetCode) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidResetCode, err.Error(),
+			fieldError{Field: "code", Message: err.Error()})
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not reset the password")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bookman/authenticate"
+	"bookman/db"
+	"bookman/notify"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps the messages instead of sending them
+type recordingNotifier struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+func (n *recordingNotifier) Notify(user *db.User, msg notify.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+// lastResetCode returns the code of the last password reset message, once
+// the codes requested so far are sent
+func (s *testServer) lastResetCode(t *testing.T) string {
+	t.Helper()
+	s.bm.background.Wait()
+	messages := s.bm.Notifier.(*recordingNotifier).messages
+	if len(messages) == 0 {
+		t.Fatal("no message is sent")
+	}
+	body := messages[len(messages)-1].Body
+	return body[strings.LastIndex(body, " ")+1:]
+}
+
+func TestChangePassword(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup(t, "alice", db.RoleMember)
+
+	w := s.do(t, http.MethodPost, "/auth/change-password", alice, `{"old_password": "wrong", "new_password": "Correct-Horse-7"}`)
+	expectStatus(t, w, http.StatusForbidden, codeWrongPassword)
+	w = s.do(t, http.MethodPost, "/auth/change-password", alice, `{"old_password": "password", "new_password": "weak"}`)
+	expectStatus(t, w, http.StatusBadRequest, codeValidationFailed)
+	expectViolations(t, w, "new_password")
+
+	w = s.do(t, http.MethodPost, "/auth/change-password", alice, `{"old_password": "password", "new_password": "Correct-Horse-7"}`)
+	expectStatus(t, w, http.StatusOK, "")
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil {
+		t.Fatal(err)
+	}
+
+	// the previous sessions end, while the new tokens are valid
+	expectStatus(t, s.do(t, http.MethodGet, "/profile", alice, ""), http.StatusUnauthorized, codeInvalidToken)
+	expectStatus(t, s.do(t, http.MethodGet, "/profile", tokens.AccessToken, ""), http.StatusOK, "")
+	w = s.do(t, http.MethodPost, "/auth/login", "", `{"username": "alice", "password": "Correct-Horse-7"}`)
+	expectStatus(t, w, http.StatusOK, "")
+}
+
+func TestPasswordReset(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup(t, "alice", db.RoleMember)
+	s.signup(t, "bob", db.RoleMember)
+
+	// unknown users get the same answer, without any message sent
+	w := s.do(t, http.MethodPost, "/auth/password-reset", "", `{"username": "nobody"}`)
+	expectStatus(t, w, http.StatusAccepted, "")
+	s.bm.background.Wait()
+	if messages := s.bm.Notifier.(*recordingNotifier).messages; len(messages) != 0 {
+		t.Fatalf("%d messages are sent for an unknown user", len(messages))
+	}
+
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/password-reset", "", `{"username": "alice"}`), http.StatusAccepted, "")
+	code := s.lastResetCode(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"wrong code", `{"username": "alice", "code": "nope", "new_password": "Correct-Horse-7"}`, http.StatusBadRequest, codeInvalidResetCode},
+		{"another user", `{"username": "bob", "code": "` + code + `", "new_password": "Correct-Horse-7"}`, http.StatusBadRequest, codeInvalidResetCode},
+		{"weak password", `{"username": "alice", "code": "` + code + `", "new_password": "password"}`, http.StatusBadRequest, codeValidationFailed},
+		{"reset", `{"username": "alice", "code": "` + code + `", "new_password": "Correct-Horse-7"}`, http.StatusNoContent, ""},
+		{"used code", `{"username": "alice", "code": "` + code + `", "new_password": "Other-Horse-8"}`, http.StatusBadRequest, codeInvalidResetCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, http.MethodPost, "/auth/password-reset/confirm", "", tt.body)
+			expectStatus(t, w, tt.status, tt.code)
+		})
+	}
+
+	expectStatus(t, s.do(t, http.MethodGet, "/profile", alice, ""), http.StatusUnauthorized, codeInvalidToken)
+	w = s.do(t, http.MethodPost, "/auth/login", "", `{"username": "alice", "password": "Correct-Horse-7"}`)
+	expectStatus(t, w, http.StatusOK, "")
+}
+
+func TestPasswordResetCodeReplacesThePreviousOne(t *testing.T) {
+	s := newTestServer(t)
+	s.signup(t, "alice", db.RoleMember)
+
+	s.do(t, http.MethodPost, "/auth/password-reset", "", `{"username": "alice"}`)
+	first := s.lastResetCode(t)
+	s.do(t, http.MethodPost, "/auth/password-reset", "", `{"username": "alice"}`)
+	s.bm.background.Wait()
+
+	w := s.do(t, http.MethodPost, "/auth/password-reset/confirm", "", `{"username": "alice", "code": "`+first+`", "new_password": "Correct-Horse-7"}`)
+	expectStatus(t, w, http.StatusBadRequest, codeInvalidResetCode)
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	s := newTestServer(t)
+	s.bm.Authenticate.LimitLogins(authenticate.LoginLimits{
+		MaxFailures:   2,
+		FailureWindow: time.Hour,
+		Lockout:       time.Hour,
+	})
+	alice := s.signup(t, "alice", db.RoleMember)
+
+	wrong := `{"old_password": "wrong", "new_password": "Correct-Horse-7"}`
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/change-password", alice, wrong), http.StatusForbidden, codeWrongPassword)
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/change-password", alice, wrong), http.StatusForbidden, codeWrongPassword)
+	w := s.do(t, http.MethodPost, "/auth/change-password", alice, `{"old_password": "password", "new_password": "Correct-Horse-7"}`)
+	expectStatus(t, w, http.StatusTooManyRequests, codeTooManyAttempts)
+}
+
+func TestPasswordResetRequestsAreThrottled(t *testing.T) {
+	s := newTestServer(t)
+	s.bm.Authenticate.LimitResetRequests(authenticate.ResetLimits{MaxPerUsername: 2, MaxPerIP: 3, Window: time.Hour})
+	s.signup(t, "alice", db.RoleMember)
+
+	request := func(username string) *httptest.ResponseRecorder {
+		return s.do(t, http.MethodPost, "/auth/password-reset", "", `{"username": "`+username+`"}`)
+	}
+	expectStatus(t, request("alice"), http.StatusAccepted, "")
+	expectStatus(t, request("alice"), http.StatusAccepted, "")
+	w := request("alice")
+	expectStatus(t, w, http.StatusTooManyRequests, codeTooManyAttempts)
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("the throttled request has no Retry-After")
+	}
+
+	// unknown usernames count against the address the same way
+	expectStatus(t, request("nobody"), http.StatusAccepted, "")
+	expectStatus(t, request("somebody"), http.StatusTooManyRequests, codeTooManyAttempts)
+
+	s.bm.background.Wait()
+	if messages := s.bm.Notifier.(*recordingNotifier).messages; len(messages) != 2 {
+		t.Fatalf("%d messages are sent, want 2", len(messages))
+	}
+}
+
+func TestPasswordResetWithoutNotifier(t *testing.T) {
+	s := newTestServer(t)
+	s.bm.Notifier = nil
+	s.signup(t, "alice", db.RoleMember)
+
+	w := s.do(t, http.MethodPost, "/auth/password-reset", "", `{"username": "alice"}`)
+	expectStatus(t, w, http.StatusServiceUnavailable, codeResetUnavailable)
+	w = s.do(t, http.MethodPost, "/auth/password-reset/confirm", "", `{"username": "alice", "code": "1234", "new_password": "Correct-Horse-7"}`)
+	expectStatus(t, w, http.StatusServiceUnavailable, codeResetUnavailable)
+}
+
+// blockingNotifier sends nothing until it is released
+type blockingNotifier struct {
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Notify(user *db.User, msg notify.Message) error {
+	<-n.release
+	return nil
+}
+
+func TestShutdownWaitsForResetCodes(t *testing.T) {
+	s := newTestServer(t)
+	notifier := &blockingNotifier{release: make(chan struct{})}
+	s.bm.Notifier = notifier
+	s.signup(t, "alice", db.RoleMember)
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/password-reset", "", `{"username": "alice"}`), http.StatusAccepted, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.bm.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v while a code is being sent, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(notifier.release)
+	if err := s.bm.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
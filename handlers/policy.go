@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"bookman/db"
+	"fmt"
+	"net/http"
+)
+
+// action is something a user may be allowed to do
+type action string
+
+const (
+	actionReadBooks     action = "read books"
+	actionCreateBook    action = "create books"
+	actionEditOwnBook   action = "edit their own books"
+	actionEditAnyBook   action = "edit any book"
+	actionDeleteOwnBook action = "delete their own books"
+	actionDeleteAnyBook action = "delete any book"
+	actionCreateAuthor  action = "create authors"
+	actionEditAuthors   action = "edit or delete authors"
+	actionManageUsers   action = "manage users"
+)
+
+// rolePermissions lists the actions allowed to every role
+var rolePermissions = map[string][]action{
+	db.RoleAdmin: {
+		actionReadBooks, actionCreateBook,
+		actionEditOwnBook, actionEditAnyBook,
+		actionDeleteOwnBook, actionDeleteAnyBook,
+		actionCreateAuthor, actionEditAuthors,
+		actionManageUsers,
+	},
+	db.RoleLibrarian: {
+		actionReadBooks, actionCreateBook,
+		actionEditOwnBook, actionEditAnyBook,
+		actionDeleteOwnBook,
+		actionCreateAuthor, actionEditAuthors,
+	},
+	db.RoleMember: {
+		actionReadBooks, actionCreateBook,
+		actionEditOwnBook, actionDeleteOwnBook,
+		actionCreateAuthor,
+	},
+	db.RoleReadOnly: {
+		actionReadBooks,
+	},
+}
+
+// can reports whether the role of the user allows the action
+func can(user *db.User, a action) bool {
+	for _, allowed := range rolePermissions[user.Role] {
+		if allowed == a {
+			return true
+		}
+	}
+	return false
+}
+
+// canModifyBook reports whether the user may take an action on a book
+// created by the given user, either as its creator or on any book
+func canModifyBook(user *db.User, createdBy string, own, any action) bool {
+	if can(user, any) {
+		return true
+	}
+	return createdBy == user.Username && can(user, own)
+}
+
+// allow responds with 403 Forbidden when the user may not take the action
+func (bm *BookManagerServer) allow(w http.ResponseWriter, user *db.User, a action) bool {
+	if can(user, a) {
+		return true
+	}
+	bm.Logger.WithField("username", user.Username).Warnf("the %s role is not allowed to %s", user.Role, a)
+	writeProblem(w, http.StatusForbidden, codeForbidden, fmt.Sprintf("the %s role is not allowed to %s", user.Role, a))
+	return false
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Error codes of the problem responses. Clients may rely on them, so they
+// must not change once released.
+const (
+	codeInvalidBody         = "invalid_body"
+	codeValidationFailed    = "validation_failed"
+	codeInvalidQuery        = "invalid_query"
+	codeUnauthorized        = "unauthorized"
+	codeInvalidToken        = "invalid_token"
+	codeInvalidCredentials  = "invalid_credentials"
+	codeTooManyAttempts     = "too_many_attempts"
+	codeInvalidChallenge    = "invalid_challenge_token"
+	codeInvalidTwoFactor    = "invalid_two_factor_code"
+	codeTwoFactorEnabled    = "two_factor_enabled"
+	codeTwoFactorDisabled   = "two_factor_disabled"
+	codeInvalidRefreshToken = "invalid_refresh_token"
+	codeRefreshTokenReused  = "refresh_token_reused"
+	codeInvalidResetCode    = "invalid_reset_code"
+	codeResetUnavailable    = "password_reset_unavailable"
+	codeWrongPassword       = "wrong_password"
+	codeForbidden           = "forbidden"
+	codeNotFound            = "not_found"
+	codeMethodNotAllowed    = "method_not_allowed"
+	codeConflict            = "conflict"
+	codeBookExists          = "book_exists"
+	codeUsernameTaken       = "username_taken"
+	codePhoneNumberTaken    = "phone_number_taken"
+	codeLastAdmin           = "last_admin"
+	codeHeirUndeletable     = "heir_undeletable"
+	codeAuthorExists        = "author_exists"
+	codeAuthorInUse         = "author_in_use"
+	codeInternal            = "internal_error"
+)
+
+// problem is the body of every error response, following RFC 7807
+type problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Code   string       `json:"code"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError tells which field of a request is invalid and why
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error lets the parsers of a request return the invalid field as an error
+func (e fieldError) Error() string {
+	return e.Message
+}
+
+// writeProblem responds with a problem details body. The type is left as
+// about:blank, so the code tells the problems apart.
+func writeProblem(w http.ResponseWriter, status int, code, detail string, fields ...fieldError) {
+	resBody, _ := json.Marshal(problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+		Errors: fields,
+	})
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	w.Write(resBody)
+}
+
+// internalError logs the error and responds with 500 Internal Server Error
+// without telling the client what went wrong
+func (bm *BookManagerServer) internalError(w http.ResponseWriter, err error, msg string, args ...interface{}) {
+	bm.Logger.WithError(err).Warn(append([]interface{}{msg}, args...)...)
+	writeProblem(w, http.StatusInternalServerError, codeInternal, "the request could not be processed")
+}
+
+// invalidBody responds with 400 Bad Request to a body which is not valid JSON
+func (bm *BookManagerServer) invalidBody(w http.ResponseWriter, err error) {
+	bm.Logger.WithError(err).Warn("can not parse the body of the request")
+	writeProblem(w, http.StatusBadRequest, codeInvalidBody, "the body of the request is not valid JSON")
+}
+
+// invalidQuery responds with 400 Bad Request to invalid query parameters,
+// naming the parameter when the error is a fieldError
+func invalidQuery(w http.ResponseWriter, err error) {
+	var field fieldError
+	if errors.As(err, &field) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidQuery, field.Message, field)
+		return
+	}
+	writeProblem(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+}
@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"bookman/authenticate"
+	"bookman/db"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 )
 
@@ -10,45 +14,145 @@ type userInfoResponse struct {
 	Firstname   string `json:"firstname"`
 	Lastname    string `json:"lastname"`
 	PhoneNumber string `json:"phone_number"`
+	Role        string `json:"role"`
 }
 
-func (bm *BookManagerServer) HandleProfile(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+func newUserInfoResponse(user *db.User) *userInfoResponse {
+	return &userInfoResponse{
+		Username:    user.Username,
+		Firstname:   user.Firstname,
+		Lastname:    user.Lastname,
+		PhoneNumber: user.PhoneNumber,
+		Role:        user.Role,
 	}
+}
 
-	//	Grab Authorization header
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		bm.Logger.Warn("token empty")
-		return
+// profileUpdateRequest keeps the fields missing from the body nil, so they
+// are left unchanged while an empty string clears a field
+type profileUpdateRequest struct {
+	Firstname   *string `json:"firstname"`
+	Lastname    *string `json:"lastname"`
+	PhoneNumber *string `json:"phone_number"`
+}
+
+func (pr *profileUpdateRequest) validate() []fieldError {
+	var rules []fieldRules
+	if pr.Firstname != nil {
+		rules = append(rules, field("firstname", *pr.Firstname, maxLength(25)))
+	}
+	if pr.Lastname != nil {
+		rules = append(rules, field("lastname", *pr.Lastname, maxLength(25)))
 	}
+	if pr.PhoneNumber != nil {
+		rules = append(rules, field("phone_number", *pr.PhoneNumber,
+			matches(phoneNumberPattern, "must be a phone number of digits, optionally starting with +")))
+	}
+	return validate(rules...)
+}
+
+type deleteProfileRequest struct {
+	Password string `json:"password"`
+}
+
+func (bm *BookManagerServer) HandleProfileForGetMethod(w http.ResponseWriter, r *http.Request) {
+	//	The user authenticated by the access token
+	user := userFromRequest(r)
 
-	//	Retrieve the related account by token
-	accountUsername, err := bm.Authenticate.GetAccountByToken(token)
+	//	Create the response body
+	res, _ := json.Marshal(newUserInfoResponse(user))
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// HandleProfileForPatchMethod changes the given names and phone number of
+// the authenticated user
+func (bm *BookManagerServer) HandleProfileForPatchMethod(w http.ResponseWriter, r *http.Request) {
+	//	The user authenticated by the access token
+	user := userFromRequest(r)
+
+	// Parse the request body for the changed fields
+	reqData, err := io.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		bm.Logger.WithError(err).Warn("retrieving account: ")
+		bm.invalidBody(w, err)
 		return
 	}
 
-	//	Retrieve user from database
-	user, err := bm.DB.GetUserByUsername(*accountUsername)
+	var pr profileUpdateRequest
+	err = json.Unmarshal(reqData, &pr)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		bm.Logger.WithError(err).Warn("retrieving user from db: ")
+		bm.invalidBody(w, err)
+		return
+	}
+	if violations := pr.validate(); len(violations) > 0 {
+		invalidFields(w, violations)
 		return
 	}
 
-	//	Create the response body
-	res, err := json.Marshal(&userInfoResponse{
-		Username:    user.Username,
-		Firstname:   user.Firstname,
-		Lastname:    user.Lastname,
-		PhoneNumber: user.PhoneNumber,
+	updatedUser, err := bm.DB.UpdateUserProfile(user.ID, db.ProfileUpdate{
+		Firstname:   pr.Firstname,
+		Lastname:    pr.Lastname,
+		PhoneNumber: pr.PhoneNumber,
 	})
+	if errors.Is(err, db.ErrPhoneNumberTaken) {
+		writeProblem(w, http.StatusConflict, codePhoneNumberTaken, err.Error(),
+			fieldError{Field: "phone_number", Message: err.Error()})
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not update the profile of user ", user.ID)
+		return
+	}
+
+	res, _ := json.Marshal(newUserInfoResponse(updatedUser))
 	w.WriteHeader(http.StatusOK)
 	w.Write(res)
 }
+
+// HandleProfileForDeleteMethod deletes the account of the authenticated
+// user, who has to give their password again
+func (bm *BookManagerServer) HandleProfileForDeleteMethod(w http.ResponseWriter, r *http.Request) {
+	//	The user authenticated by the access token
+	user := userFromRequest(r)
+
+	// Parse the request body for the password
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	var dr deleteProfileRequest
+	err = json.Unmarshal(reqData, &dr)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+	if violations := validate(field("password", dr.Password, required())); len(violations) > 0 {
+		invalidFields(w, violations)
+		return
+	}
+
+	ip := bm.clientIP(r)
+	err = bm.Authenticate.DeleteAccount(user, dr.Password, ip)
+	if bm.lockedOut(w, err, user.Username, ip) {
+		return
+	}
+	if errors.Is(err, authenticate.ErrWrongPassword) {
+		writeProblem(w, http.StatusForbidden, codeWrongPassword, err.Error(),
+			fieldError{Field: "password", Message: err.Error()})
+		return
+	}
+	if errors.Is(err, db.ErrLastAdmin) {
+		writeProblem(w, http.StatusConflict, codeLastAdmin, err.Error())
+		return
+	}
+	if errors.Is(err, db.ErrHeirUndeletable) {
+		writeProblem(w, http.StatusConflict, codeHeirUndeletable, err.Error())
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not delete user ", user.ID)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"bookman/authenticate"
+	"bookman/db"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup(t, "alice", db.RoleMember)
+	bob := s.signup(t, "bob", db.RoleMember)
+
+	w := s.do(t, http.MethodPatch, "/profile", alice, `{"firstname": "Alice", "phone_number": "+15550100"}`)
+	expectStatus(t, w, http.StatusOK, "")
+	var profile userInfoResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Firstname != "Alice" || profile.PhoneNumber != "+15550100" {
+		t.Fatalf("got the profile %+v", profile)
+	}
+
+	// the fields missing from the body are kept
+	w = s.do(t, http.MethodPatch, "/profile", alice, `{"lastname": "Liddell"}`)
+	expectStatus(t, w, http.StatusOK, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Firstname != "Alice" || profile.Lastname != "Liddell" || profile.PhoneNumber != "+15550100" {
+		t.Fatalf("got the profile %+v", profile)
+	}
+
+	w = s.do(t, http.MethodPatch, "/profile", bob, `{"phone_number": "+15550100"}`)
+	expectStatus(t, w, http.StatusConflict, codePhoneNumberTaken)
+	w = s.do(t, http.MethodPatch, "/profile", bob, `{"phone_number": "call me", "firstname": "A name much longer than twenty five characters"}`)
+	expectStatus(t, w, http.StatusBadRequest, codeValidationFailed)
+	expectViolations(t, w, "firstname", "phone_number")
+
+	// a phone number given up can be taken by another user
+	expectStatus(t, s.do(t, http.MethodPatch, "/profile", alice, `{"phone_number": ""}`), http.StatusOK, "")
+	expectStatus(t, s.do(t, http.MethodPatch, "/profile", bob, `{"phone_number": "+15550100"}`), http.StatusOK, "")
+}
+
+func TestDeleteProfile(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup(t, "alice", db.RoleMember)
+	bob := s.signup(t, "bob", db.RoleMember)
+	expectStatus(t, s.do(t, http.MethodPost, "/books", alice, testBook), http.StatusCreated, "")
+
+	expectStatus(t, s.do(t, http.MethodDelete, "/profile", alice, `{"password": "wrong"}`), http.StatusForbidden, codeWrongPassword)
+	expectStatus(t, s.do(t, http.MethodDelete, "/profile", alice, `{}`), http.StatusBadRequest, codeValidationFailed)
+	expectStatus(t, s.do(t, http.MethodDelete, "/profile", alice, `{"password": "password"}`), http.StatusNoContent, "")
+
+	expectStatus(t, s.do(t, http.MethodGet, "/profile", alice, ""), http.StatusUnauthorized, codeInvalidToken)
+
+	// the books of the user are kept by default, without its name
+	w := s.do(t, http.MethodGet, "/books/1", bob, "")
+	expectStatus(t, w, http.StatusOK, "")
+	var book bookRequestResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &book); err != nil {
+		t.Fatal(err)
+	}
+	if book.CreatedBy != db.GhostUsername {
+		t.Fatalf("the book is created by %q, want %q", book.CreatedBy, db.GhostUsername)
+	}
+
+	// the username is free again
+	body := `{"username": "alice", "password": "Correct-Horse-7"}`
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/signup", "", body), http.StatusCreated, "")
+}
+
+func TestDeleteLastAdmin(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.signup(t, "alice", db.RoleAdmin)
+
+	w := s.do(t, http.MethodDelete, "/profile", admin, `{"password": "password"}`)
+	expectStatus(t, w, http.StatusConflict, codeLastAdmin)
+}
+
+func TestDeleteProfileLockout(t *testing.T) {
+	s := newTestServer(t)
+	s.bm.Authenticate.LimitLogins(authenticate.LoginLimits{
+		MaxFailures:   2,
+		FailureWindow: time.Hour,
+		Lockout:       time.Hour,
+	})
+	alice := s.signup(t, "alice", db.RoleMember)
+
+	expectStatus(t, s.do(t, http.MethodDelete, "/profile", alice, `{"password": "wrong"}`), http.StatusForbidden, codeWrongPassword)
+	expectStatus(t, s.do(t, http.MethodDelete, "/profile", alice, `{"password": "wrong"}`), http.StatusForbidden, codeWrongPassword)
+	w := s.do(t, http.MethodDelete, "/profile", alice, `{"password": "password"}`)
+	expectStatus(t, w, http.StatusTooManyRequests, codeTooManyAttempts)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Router routes the requests to the handlers. Every route requires an access
+// token except the public ones used to get a token or reset a password.
+func (bm *BookManagerServer) Router() *mux.Router {
+	router := mux.NewRouter()
+
+	// Public routes
+	router.HandleFunc("/auth/signup", bm.HandleSignUp).Methods(http.MethodPost)
+	router.HandleFunc("/auth/login", bm.HandleLogin).Methods(http.MethodPost)
+	router.HandleFunc("/auth/login/2fa", bm.HandleLoginSecondFactor).Methods(http.MethodPost)
+	router.HandleFunc("/auth/refresh", bm.HandleRefresh).Methods(http.MethodPost)
+	router.HandleFunc("/auth/password-reset", bm.HandlePasswordResetRequest).Methods(http.MethodPost)
+	router.HandleFunc("/auth/password-reset/confirm", bm.HandlePasswordReset).Methods(http.MethodPost)
+
+	// Routes of authenticated users
+	private := router.PathPrefix("/").Subrouter()
+	private.Use(bm.Authenticated)
+	private.HandleFunc("/auth/logout", bm.HandleLogout).Methods(http.MethodPost)
+	private.HandleFunc("/auth/logout-all", bm.HandleLogoutAll).Methods(http.MethodPost)
+	private.HandleFunc("/auth/change-password", bm.HandleChangePassword).Methods(http.MethodPost)
+	private.HandleFunc("/auth/2fa", bm.HandleTwoFactorEnroll).Methods(http.MethodPost)
+	private.HandleFunc("/auth/2fa", bm.HandleTwoFactorDisable).Methods(http.MethodDelete)
+	private.HandleFunc("/auth/2fa/confirm", bm.HandleTwoFactorConfirm).Methods(http.MethodPost)
+	private.HandleFunc("/profile", bm.HandleProfileForGetMethod).Methods(http.MethodGet)
+	private.HandleFunc("/profile", bm.HandleProfileForPatchMethod).Methods(http.MethodPatch)
+	private.HandleFunc("/profile", bm.HandleProfileForDeleteMethod).Methods(http.MethodDelete)
+
+	private.HandleFunc("/books", bm.HandleBooksForGetMethod).Methods(http.MethodGet)
+	private.HandleFunc("/books", bm.HandleBooksForPostMethod).Methods(http.MethodPost)
+	private.HandleFunc("/books/{id:[1-9][0-9]*}", bm.HandleOneBookForGetMethod).Methods(http.MethodGet)
+	private.HandleFunc("/books/{id:[1-9][0-9]*}", bm.HandleOneBookForPutMethod).Methods(http.MethodPut)
+	private.HandleFunc("/books/{id:[1-9][0-9]*}", bm.HandleOneBookForPatchMethod).Methods(http.MethodPatch)
+	private.HandleFunc("/books/{id:[1-9][0-9]*}", bm.HandleOneBookForDeleteMethod).Methods(http.MethodDelete)
+
+	private.HandleFunc("/authors", bm.HandleAuthorsForGetMethod).Methods(http.MethodGet)
+	private.HandleFunc("/authors", bm.HandleAuthorsForPostMethod).Methods(http.MethodPost)
+	private.HandleFunc("/authors/{id:[1-9][0-9]*}", bm.HandleOneAuthorForGetMethod).Methods(http.MethodGet)
+	private.HandleFunc("/authors/{id:[1-9][0-9]*}", bm.HandleOneAuthorForPatchMethod).Methods(http.MethodPatch)
+	private.HandleFunc("/authors/{id:[1-9][0-9]*}", bm.HandleOneAuthorForDeleteMethod).Methods(http.MethodDelete)
+
+	private.HandleFunc("/search", bm.HandleSearch).Methods(http.MethodGet)
+	private.HandleFunc("/admin/users/{id:[1-9][0-9]*}/role", bm.HandleUserRole).Methods(http.MethodPut)
+	private.HandleFunc("/admin/lockouts", bm.HandleLockoutsForGetMethod).Methods(http.MethodGet)
+	private.HandleFunc("/admin/lockouts", bm.HandleLockoutsForDeleteMethod).Methods(http.MethodDelete)
+
+	// A known path requested with another method, including OPTIONS, is
+	// answered with the methods it supports before authenticating
+	router.MethodNotAllowedHandler = methodNotAllowed(router)
+	router.NotFoundHandler = http.HandlerFunc(notFound)
+
+	return router
+}
+
+// routedMethods are the methods the routes are registered with
+var routedMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// methodNotAllowed responds to OPTIONS with the methods of the path, and to
+// any other method with 405 Method Not Allowed
+func methodNotAllowed(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := allowedMethods(router, r)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeProblem(w, http.StatusMethodNotAllowed, codeMethodNotAllowed,
+			fmt.Sprintf("the method %s is not allowed for this path", r.Method))
+	})
+}
+
+// notFound responds with 404 Not Found to a path which has no route
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, http.StatusNotFound, codeNotFound, "there is no resource at this path")
+}
+
+// allowedMethods lists the methods a route is registered with for the path of
+// the request
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	for _, method := range routedMethods {
+		req := r.Clone(r.Context())
+		req.Method = method
+		var match mux.RouteMatch
+		if router.Match(req, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return append(allowed, http.MethodOptions)
+}
+
+// idFromPath reads the ID in the URL, which the routes only match when it is
+// a positive number
+func idFromPath(r *http.Request) uint {
+	id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	return uint(id)
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"bookman/db"
+	"net/http"
+	"testing"
+)
+
+func TestUnroutedMethods(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.signup(t, "alice", db.RoleMember)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+		code   string
+		allow  string
+	}{
+		{"options", http.MethodOptions, "/books", alice, http.StatusNoContent, "", "GET, POST, OPTIONS"},
+		{"options without a token", http.MethodOptions, "/books/1", "", http.StatusNoContent, "", "GET, PUT, PATCH, DELETE, OPTIONS"},
+		{"options of a public path", http.MethodOptions, "/auth/login", "", http.StatusNoContent, "", "POST, OPTIONS"},
+		{"another method", http.MethodDelete, "/books", alice, http.StatusMethodNotAllowed, codeMethodNotAllowed, "GET, POST, OPTIONS"},
+		{"another method without a token", http.MethodPut, "/profile", "", http.StatusMethodNotAllowed, codeMethodNotAllowed, "GET, PATCH, DELETE, OPTIONS"},
+		{"unknown path", http.MethodGet, "/nowhere", alice, http.StatusNotFound, codeNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do(t, tt.method, tt.path, tt.token, "")
+			expectStatus(t, w, tt.status, tt.code)
+			if allow := w.Header().Get("Allow"); allow != tt.allow {
+				t.Fatalf("Allow is %q, want %q", allow, tt.allow)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultSearchLimit = 20
+
+type searchSnippet struct {
+	Field string `json:"field"`
+	Text  string `json:"text"`
+}
+
+type searchResult struct {
+	Book     bookRequestResponse `json:"book"`
+	Rank     float64             `json:"rank"`
+	Snippets []searchSnippet     `json:"snippets"`
+}
+
+func (bm *BookManagerServer) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	//	The user authenticated by the access token
+	user := userFromRequest(r)
+	if !bm.allow(w, user, actionReadBooks) {
+		return
+	}
+
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" {
+		invalidQuery(w, fieldError{Field: "q", Message: "the search text q is empty"})
+		return
+	}
+	limit := defaultSearchLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			invalidQuery(w, fieldError{Field: "limit", Message: fmt.Sprintf("the limit must be between 1 and %d", maxPageLimit)})
+			return
+		}
+	}
+
+	//	Rank the books matching the text
+	hits, err := bm.DB.SearchBooks(text, limit)
+	if err != nil {
+		bm.internalError(w, err, "can not search books")
+		return
+	}
+
+	results := []searchResult{}
+	for i := range hits {
+		snippets := []searchSnippet{}
+		for _, s := range hits[i].Snippets {
+			snippets = append(snippets, searchSnippet{Field: s.Field, Text: s.Text})
+		}
+		results = append(results, searchResult{
+			Book:     newBookResponse(&hits[i].Book),
+			Rank:     hits[i].Rank,
+			Snippets: snippets,
+		})
+	}
+	response := map[string]interface{}{
+		"results": results,
+	}
+
+	resBody, _ := json.Marshal(response)
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBody)
+}
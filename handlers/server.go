@@ -3,12 +3,43 @@ package handlers
 import (
 	"bookman/authenticate"
 	"bookman/db"
+	"bookman/notify"
+	"context"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 )
 
 type BookManagerServer struct {
-	DB           *db.GormDB
+	DB           db.Store
 	Logger       *logrus.Logger
 	Authenticate *authenticate.Auth
+	Passwords    *authenticate.PasswordPolicy
+	// Notifier sends the password reset codes, resets are unavailable
+	// without it
+	Notifier notify.Notifier
+	// TrustForwardedFor takes the address of the client from the
+	// X-Forwarded-For header, set it only behind a reverse proxy
+	TrustForwardedFor bool
+
+	// background tracks the work left running after a response, such as
+	// sending reset codes
+	background sync.WaitGroup
+}
+
+// Shutdown waits for the work left running after the responses, such as the
+// reset codes still being sent, or until the context is done. Call it once
+// the http server stopped taking requests.
+func (bm *BookManagerServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		bm.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
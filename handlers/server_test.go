@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bookman/authenticate"
+	"bookman/config"
+	"bookman/db"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testServer routes requests to a BookManagerServer on an in-memory database
+type testServer struct {
+	bm     *BookManagerServer
+	router *mux.Router
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	var cfg config.Config
+	cfg.Database.Driver = db.DriverSQLite
+	cfg.Database.Path = ":memory:"
+	cfg.Password.BcryptCost = bcrypt.MinCost
+	gdb, err := db.NewGormDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = gdb.CreateSchema(); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	keys, err := authenticate.NewRandomKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := authenticate.NewAuth(gdb, logger, keys, authenticate.TokenConfig{
+		Issuer:          "bookman-test",
+		Audience:        "bookman-test",
+		Lifetime:        10 * time.Minute,
+		RefreshLifetime: time.Hour,
+		ClockSkew:       time.Second,
+		ResetLifetime:   time.Hour,
+
+		ChallengeLifetime: 5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Password.MinLength = 10
+	cfg.Password.MinCharacterClasses = 3
+	passwords, err := authenticate.NewPasswordPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := &BookManagerServer{
+		DB:           gdb,
+		Logger:       logger,
+		Authenticate: auth,
+		Passwords:    passwords,
+		Notifier:     &recordingNotifier{},
+	}
+	return &testServer{bm: bm, router: bm.Router()}
+}
+
+// signup adds a user with the given role and returns its access token
+func (s *testServer) signup(t *testing.T, username, role string) string {
+	t.Helper()
+	user := db.User{Username: username, Password: "password"}
+	if err := s.bm.DB.CreateNewUser(&user); err != nil {
+		t.Fatal(err)
+	}
+	if role != db.RoleMember {
+		if err := s.bm.DB.SetUserRole(user.ID, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, err := s.bm.Authenticate.Login(authenticate.Credentials{Username: username, Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.TokenString
+}
+
+// do sends a request with the token, when given, and the JSON body
+func (s *testServer) do(t *testing.T, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+// expectStatus fails the test when the response has another status, and
+// checks that errors are answered with a problem of the given code
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	if status < http.StatusBadRequest {
+		return
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("expected a problem, got the content type %q", ct)
+	}
+	var p problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != status || p.Code != code {
+		t.Fatalf("expected the problem %d %s, got %d %s", status, code, p.Status, p.Code)
+	}
+}
+
+// expectViolations fails the test unless the problem lists exactly the given
+// invalid fields, in order
+func expectViolations(t *testing.T, w *httptest.ResponseRecorder, fields ...string) {
+	t.Helper()
+	var p problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range p.Errors {
+		got = append(got, e.Field)
+	}
+	if strings.Join(got, " ") != strings.Join(fields, " ") {
+		t.Fatalf("expected the invalid fields %v, got %v", fields, got)
+	}
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"bookman/authenticate"
+	"bookman/db"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type twoFactorDisableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type loginSecondFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+// writeChallenge responds to a correct password of a user with two-factor
+// authentication with the challenge to complete at /auth/login/2fa
+func writeChallenge(w http.ResponseWriter, challenge *authenticate.SecondFactorRequiredError) {
+	response := map[string]interface{}{
+		"two_factor_required": true,
+		"challenge_token":     challenge.Challenge,
+		"expires_in":          int(challenge.ExpiresIn.Seconds()),
+	}
+	resBody, _ := json.Marshal(response)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBody)
+}
+
+// HandleLoginSecondFactor completes a login with the challenge token and a
+// code of the authenticator app, or a recovery code
+func (bm *BookManagerServer) HandleLoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	// Parse the request body for the challenge and the code
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	var lr loginSecondFactorRequest
+	err = json.Unmarshal(reqData, &lr)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+	violations := validate(
+		field("challenge_token", lr.ChallengeToken, required()),
+		field("code", lr.Code, required()),
+	)
+	if len(violations) > 0 {
+		invalidFields(w, violations)
+		return
+	}
+
+	ip := bm.clientIP(r)
+	token, err := bm.Authenticate.LoginSecondFactor(lr.ChallengeToken, lr.Code, ip)
+	if bm.lockedOut(w, err, "", ip) {
+		return
+	}
+	if errors.Is(err, authenticate.ErrInvalidChallenge) {
+		writeProblem(w, http.StatusUnauthorized, codeInvalidChallenge, err.Error())
+		return
+	}
+	if errors.Is(err, authenticate.ErrInvalidTwoFactorCode) {
+		bm.Logger.WithField("ip", ip).Warn("a wrong two-factor code is given")
+		writeProblem(w, http.StatusUnauthorized, codeInvalidTwoFactor, err.Error(),
+			fieldError{Field: "code", Message: err.Error()})
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not complete the login")
+		return
+	}
+
+	writeTokens(w, token)
+}
+
+// HandleTwoFactorEnroll creates a new secret for the authenticated user to
+// add to an authenticator app. It is pending until a code is confirmed.
+func (bm *BookManagerServer) HandleTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	//	The user authenticated by the access token
+	user := userFromRequest(r)
+
+	secret, uri, err := bm.Authenticate.EnrollTwoFactor(user)
+	if errors.Is(err, db.ErrTwoFactorEnabled) {
+		writeProblem(w, http.StatusConflict, codeTwoFactorEnabled, err.Error())
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not enroll a second factor of user ", user.ID)
+		return
+	}
+
+	response := map[string]interface{}{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	}
+	resBody, _ := json.Marshal(response)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBody)
+}
+
+// HandleTwoFactorConfirm enables two-factor authentication with a code of
+// the enrolled secret, and returns the recovery codes once
+func (bm *BookManagerServer) HandleTwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	//	The user authenticated by the access token
+	user := userFromRequest(r)
+
+	// Parse the request body for the code
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	var cr twoFactorCodeRequest
+	err = json.Unmarshal(reqData, &cr)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+	if violations := validate(field("code", cr.Code, required())); len(violations) > 0 {
+		invalidFields(w, violations)
+		return
+	}
+
+	ip := bm.clientIP(r)
+	codes, err := bm.Authenticate.ConfirmTwoFactor(user, cr.Code, ip)
+	if bm.lockedOut(w, err, user.Username, ip) {
+		return
+	}
+	if errors.Is(err, authenticate.ErrInvalidTwoFactorCode) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidTwoFactor, err.Error(),
+			fieldError{Field: "code", Message: err.Error()})
+		return
+	}
+	if errors.Is(err, authenticate.ErrTwoFactorNotEnrolled) {
+		writeProblem(w, http.StatusConflict, codeTwoFactorDisabled, err.Error())
+		return
+	}
+	if errors.Is(err, db.ErrTwoFactorEnabled) {
+		writeProblem(w, http.StatusConflict, codeTwoFactorEnabled, err.Error())
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not confirm the second factor of user ", user.ID)
+		return
+	}
+
+	response := map[string]interface{}{
+		"recovery_codes": codes,
+	}
+	resBody, _ := json.Marshal(response)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBody)
+}
+
+// HandleTwoFactorDisable turns two-factor authentication off once the user
+// gives the password and a code, or a recovery code, again
+func (bm *BookManagerServer) HandleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	//	The user authenticated by the access token
+	user := userFromRequest(r)
+
+	// Parse the request body for the password and the code
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	var dr twoFactorDisableRequest
+	err = json.Unmarshal(reqData, &dr)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+	violations := validate(
+		field("password", dr.Password, required()),
+		field("code", dr.Code, required()),
+	)
+	if len(violations) > 0 {
+		invalidFields(w, violations)
+		return
+	}
+
+	ip := bm.clientIP(r)
+	err = bm.Authenticate.DisableTwoFactor(user, dr.Password, dr.Code, ip)
+	if bm.lockedOut(w, err, user.Username, ip) {
+		return
+	}
+	if errors.Is(err, authenticate.ErrWrongPassword) {
+		writeProblem(w, http.StatusForbidden, codeWrongPassword, err.Error(),
+			fieldError{Field: "password", Message: err.Error()})
+		return
+	}
+	if errors.Is(err, authenticate.ErrInvalidTwoFactorCode) {
+		writeProblem(w, http.StatusForbidden, codeInvalidTwoFactor, err.Error(),
+			fieldError{Field: "code", Message: err.Error()})
+		return
+	}
+	if errors.Is(err, authenticate.ErrTwoFactorDisabled) {
+		writeProblem(w, http.StatusConflict, codeTwoFactorDisabled, err.Error())
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not disable the second factor of user ", user.ID)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bookman/authenticate"
+	"bookman/db"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// authenticatorCode is the code an authenticator app shows for the secret,
+// the given number of time steps from now
+func authenticatorCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(time.Now().Unix()/30+step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+// decode reads the JSON body of a response
+func decode(t *testing.T, body []byte, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	s := newTestServer(t)
+	token := s.signup(t, "admin", db.RoleAdmin)
+
+	w := s.do(t, http.MethodPost, "/auth/2fa", token, "")
+	expectStatus(t, w, http.StatusOK, "")
+	var enrollment struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}
+	decode(t, w.Body.Bytes(), &enrollment)
+
+	w = s.do(t, http.MethodPost, "/auth/2fa/confirm", token, `{"code": "abc"}`)
+	expectStatus(t, w, http.StatusBadRequest, codeInvalidTwoFactor)
+	w = s.do(t, http.MethodPost, "/auth/2fa/confirm", token, fmt.Sprintf(`{"code": %q}`, authenticatorCode(t, enrollment.Secret, 0)))
+	expectStatus(t, w, http.StatusOK, "")
+	var confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decode(t, w.Body.Bytes(), &confirmation)
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/2fa", token, ""), http.StatusConflict, codeTwoFactorEnabled)
+
+	// the password alone gives a challenge instead of tokens
+	w = s.do(t, http.MethodPost, "/auth/login", "", `{"username": "admin", "password": "password"}`)
+	expectStatus(t, w, http.StatusOK, "")
+	var challenge struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+		AccessToken       string `json:"access_token"`
+	}
+	decode(t, w.Body.Bytes(), &challenge)
+	if !challenge.TwoFactorRequired || challenge.ChallengeToken == "" || challenge.AccessToken != "" {
+		t.Fatalf("unexpected login response %s", w.Body.String())
+	}
+	expectStatus(t, s.do(t, http.MethodGet, "/profile", challenge.ChallengeToken, ""), http.StatusUnauthorized, codeInvalidToken)
+
+	body := func(code string) string {
+		return fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge.ChallengeToken, code)
+	}
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/login/2fa", "", body("123")), http.StatusUnauthorized, codeInvalidTwoFactor)
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/login/2fa", "", `{"challenge_token": "x", "code": "123456"}`),
+		http.StatusUnauthorized, codeInvalidChallenge)
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/login/2fa", "", body(confirmation.RecoveryCodes[0])), http.StatusOK, "")
+	expectStatus(t, s.do(t, http.MethodPost, "/auth/login/2fa", "", body(confirmation.RecoveryCodes[0])),
+		http.StatusUnauthorized, codeInvalidTwoFactor)
+
+	// disabling asks for the password and a code again
+	expectStatus(t, s.do(t, http.MethodDelete, "/auth/2fa", token, `{"password": "wrong", "code": "123456"}`),
+		http.StatusForbidden, codeWrongPassword)
+	expectStatus(t, s.do(t, http.MethodDelete, "/auth/2fa", token, `{"password": "password", "code": "123"}`),
+		http.StatusForbidden, codeInvalidTwoFactor)
+	w = s.do(t, http.MethodDelete, "/auth/2fa", token, `{"password": "password"}`)
+	expectStatus(t, w, http.StatusBadRequest, codeValidationFailed)
+	expectViolations(t, w, "code")
+	disable := fmt.Sprintf(`{"password": "password", "code": %q}`, authenticatorCode(t, enrollment.Secret, 1))
+	expectStatus(t, s.do(t, http.MethodDelete, "/auth/2fa", token, disable), http.StatusNoContent, "")
+	expectStatus(t, s.do(t, http.MethodDelete, "/auth/2fa", token, disable), http.StatusConflict, codeTwoFactorDisabled)
+
+	w = s.do(t, http.MethodPost, "/auth/login", "", `{"username": "admin", "password": "password"}`)
+	expectStatus(t, w, http.StatusOK, "")
+	decode(t, w.Body.Bytes(), &challenge)
+	if challenge.AccessToken == "" {
+		t.Fatalf("the password is not enough after disabling: %s", w.Body.String())
+	}
+}
+
+func TestTwoFactorDisableLockout(t *testing.T) {
+	s := newTestServer(t)
+	token := s.signup(t, "alice", db.RoleMember)
+
+	w := s.do(t, http.MethodPost, "/auth/2fa", token, "")
+	expectStatus(t, w, http.StatusOK, "")
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	decode(t, w.Body.Bytes(), &enrollment)
+	w = s.do(t, http.MethodPost, "/auth/2fa/confirm", token, fmt.Sprintf(`{"code": %q}`, authenticatorCode(t, enrollment.Secret, 0)))
+	expectStatus(t, w, http.StatusOK, "")
+
+	s.bm.Authenticate.LimitLogins(authenticate.LoginLimits{
+		MaxFailures:   2,
+		FailureWindow: time.Hour,
+		Lockout:       time.Hour,
+	})
+	wrong := `{"password": "wrong", "code": "000000"}`
+	expectStatus(t, s.do(t, http.MethodDelete, "/auth/2fa", token, wrong), http.StatusForbidden, codeWrongPassword)
+	expectStatus(t, s.do(t, http.MethodDelete, "/auth/2fa", token, wrong), http.StatusForbidden, codeWrongPassword)
+	right := fmt.Sprintf(`{"password": "password", "code": %q}`, authenticatorCode(t, enrollment.Secret, 0))
+	w = s.do(t, http.MethodDelete, "/auth/2fa", token, right)
+	expectStatus(t, w, http.StatusTooManyRequests, codeTooManyAttempts)
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("the lockout has no Retry-After")
+	}
+}
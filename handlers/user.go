@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bookman/db"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+type userRoleRequestResponse struct {
+	ID       uint   `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// HandleUserRole lets admins change the role of a user
+func (bm *BookManagerServer) HandleUserRole(w http.ResponseWriter, r *http.Request) {
+	//	The user authenticated by the access token
+	user := userFromRequest(r)
+	if !bm.allow(w, user, actionManageUsers) {
+		return
+	}
+
+	userID := idFromPath(r)
+	// an admin demoting themselves could leave nobody to manage users
+	if userID == user.ID {
+		writeProblem(w, http.StatusConflict, codeConflict, "you can not change your own role")
+		return
+	}
+
+	// Parse the request body for the new role
+	reqData, err := io.ReadAll(r.Body)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	var rr userRoleRequestResponse
+	err = json.Unmarshal(reqData, &rr)
+	if err != nil {
+		bm.invalidBody(w, err)
+		return
+	}
+
+	err = bm.DB.SetUserRole(userID, rr.Role)
+	if errors.Is(err, db.ErrInvalidRole) {
+		writeProblem(w, http.StatusBadRequest, codeValidationFailed, err.Error(),
+			fieldError{Field: "role", Message: err.Error()})
+		return
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "the user does not exist")
+		return
+	}
+	if err != nil {
+		bm.internalError(w, err, "can not change the role of user ", userID)
+		return
+	}
+
+	changedUser, err := bm.DB.GetUserByID(userID)
+	if err != nil {
+		bm.internalError(w, err, "can not retrieve user ", userID)
+		return
+	}
+	resBody, _ := json.Marshal(userRoleRequestResponse{
+		ID:       changedUser.ID,
+		Username: changedUser.Username,
+		Role:     changedUser.Role,
+	})
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBody)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bookman/authenticate"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// check is a rule for a value of a request. It returns why the value breaks
+// the rule, or an empty string when it follows it.
+type check func(value string) string
+
+// fieldRules are the checks a field of a request must pass
+type fieldRules struct {
+	name   string
+	value  string
+	checks []check
+}
+
+func field(name, value string, checks ...check) fieldRules {
+	return fieldRules{name: name, value: value, checks: checks}
+}
+
+// validate runs the checks of every field and returns all the violations.
+// Only the first failed check of a field is reported, and the checks other
+// than required let an empty value through.
+func validate(fields ...fieldRules) []fieldError {
+	var violations []fieldError
+	for _, f := range fields {
+		for _, c := range f.checks {
+			if msg := c(f.value); msg != "" {
+				violations = append(violations, fieldError{Field: f.name, Message: msg})
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// invalidFields responds with 400 Bad Request listing the violations
+func invalidFields(w http.ResponseWriter, violations []fieldError) {
+	writeProblem(w, http.StatusBadRequest, codeValidationFailed, "the request has invalid fields", violations...)
+}
+
+func required() check {
+	return func(value string) string {
+		if strings.TrimSpace(value) == "" {
+			return "this field is required"
+		}
+		return ""
+	}
+}
+
+// requiredIf is required when the condition holds, as for the fields of a
+// book which may be left out when only some of them are changed
+func requiredIf(condition bool) check {
+	if !condition {
+		return func(string) string { return "" }
+	}
+	return required()
+}
+
+func minLength(n int) check {
+	return func(value string) string {
+		if value != "" && utf8.RuneCountInString(value) < n {
+			return fmt.Sprintf("must be at least %d characters long", n)
+		}
+		return ""
+	}
+}
+
+func maxLength(n int) check {
+	return func(value string) string {
+		if utf8.RuneCountInString(value) > n {
+			return fmt.Sprintf("must be at most %d characters long", n)
+		}
+		return ""
+	}
+}
+
+func oneOf(values ...string) check {
+	return func(value string) string {
+		if value == "" {
+			return ""
+		}
+		for _, v := range values {
+			if value == v {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(values, ", ")
+	}
+}
+
+// date accepts the YYYY-MM-DD dates books are filtered and sorted by
+func date() check {
+	return func(value string) string {
+		if value == "" {
+			return ""
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return "must be a date formatted as YYYY-MM-DD"
+		}
+		return ""
+	}
+}
+
+// passwordPolicy requires a password the policy allows for the user
+func passwordPolicy(policy *authenticate.PasswordPolicy, username string) check {
+	return func(value string) string {
+		if err := policy.Check(value, username); err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+}
+
+func matches(pattern *regexp.Regexp, description string) check {
+	return func(value string) string {
+		if value != "" && !pattern.MatchString(value) {
+			return description
+		}
+		return ""
+	}
+}
+
+var (
+	usernamePattern    = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	phoneNumberPattern = regexp.MustCompile(`^\+?[0-9]{4,14}$`)
+)
@@ -5,14 +5,22 @@ import (
 	"bookman/config"
 	"bookman/db"
 	"bookman/handlers"
-	"github.com/gorilla/mux"
+	"bookman/notify"
+	"context"
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/sirupsen/logrus"
 )
 
+// shutdownTimeout bounds how long the server finishes its work once asked to stop
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	// Read the configuration
 	var cfg config.Config
@@ -25,7 +33,15 @@ func main() {
 	logger := logrus.New()
 	logger.SetLevel(logrus.DebugLevel)
 	logger.SetReportCaller(true)
-	logger.WithField("config", cfg).Infof("Setting up the configuration.")
+	logger.WithField("config", cfg.Redacted()).Infof("Setting up the configuration.")
+
+	// Run the requested command, e.g. "migrate status", instead of the server
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, os.Args[1:]); err != nil {
+			logger.WithError(err).Fatalln("can not run the command")
+		}
+		return
+	}
 
 	// Create a new instance of database
 	gormDB, err := db.NewGormDB(cfg)
@@ -34,30 +50,94 @@ func main() {
 	}
 	logger.Infoln("connected to the book management database")
 
-	// Create schemas of models
+	// Apply pending migrations
 	err = gormDB.CreateSchema()
 	if err != nil {
-		logger.WithError(err).Fatalln("can not auto migrate")
+		logger.WithError(err).Fatalln("can not migrate the database")
 	}
 	logger.Infoln("migrate tables successfully")
 
+	// Make sure the books of deleted users have somewhere to go
+	err = gormDB.CheckBookHeir()
+	if err != nil {
+		logger.WithError(err).Fatalln("can not find the user receiving the books of deleted users")
+	}
+
+	// Load the keys tokens are signed with
+	keys, err := authenticate.LoadKeySet(cfg)
+	if err != nil {
+		logger.WithError(err).Fatalln("can not load the signing keys")
+	}
+
 	// Create a new instance of authenticate
-	auth, err := authenticate.NewAuth(gormDB, logger, 10*time.Minute)
+	auth, err := authenticate.NewAuth(gormDB, logger, keys, authenticate.TokenConfig{
+		Issuer:            cfg.Auth.Issuer,
+		Audience:          cfg.Auth.Audience,
+		Lifetime:          cfg.Auth.AccessTokenLifetime,
+		RefreshLifetime:   cfg.Auth.RefreshTokenLifetime,
+		ClockSkew:         cfg.Auth.ClockSkew,
+		ResetLifetime:     cfg.Auth.PasswordResetLifetime,
+		ChallengeLifetime: cfg.Auth.TwoFactorChallengeLifetime,
+	})
 	if err != nil {
 		logger.WithError(err).Fatalln("can not create an instance of authenticate")
 	}
 
+	limits, err := authenticate.NewLoginLimits(cfg)
+	if err != nil {
+		logger.WithError(err).Fatalln("can not load the login limits")
+	}
+	auth.LimitLogins(limits)
+
+	resetLimits, err := authenticate.NewResetLimits(cfg)
+	if err != nil {
+		logger.WithError(err).Fatalln("can not load the password reset limits")
+	}
+	auth.LimitResetRequests(resetLimits)
+
+	passwords, err := authenticate.NewPasswordPolicy(cfg)
+	if err != nil {
+		logger.WithError(err).Fatalln("can not load the password policy")
+	}
+
+	notifier, err := notify.New(cfg, logger)
+	if err != nil {
+		logger.WithError(err).Fatalln("can not create the notifier")
+	}
+	if notifier == nil {
+		logger.Warnln("no notifier sender is configured, password resets are unavailable")
+	}
+
 	bookManagerServer := handlers.BookManagerServer{
 		DB:           gormDB,
 		Logger:       logger,
 		Authenticate: auth,
+		Passwords:    passwords,
+		Notifier:     notifier,
+
+		TrustForwardedFor: cfg.Login.TrustForwardedFor,
+	}
+	server := &http.Server{Addr: ":8080", Handler: bookManagerServer.Router()}
+
+	// Serve until the process is asked to stop
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		err := server.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.WithError(err).Fatalln("can not run the http server")
+		}
+	}()
+	<-stop.Done()
+
+	// Finish the requests in flight and the reset codes still being sent
+	logger.Infoln("shutting down the http server")
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err = server.Shutdown(ctx); err != nil {
+		logger.WithError(err).Warnln("can not finish the requests in flight")
+	}
+	if err = bookManagerServer.Shutdown(ctx); err != nil {
+		logger.WithError(err).Warnln("can not finish sending the reset codes")
 	}
-	router := mux.NewRouter()
-	router.HandleFunc("/auth/signup", bookManagerServer.HandleSignUp)
-	router.HandleFunc("/auth/login", bookManagerServer.HandleLogin)
-	router.HandleFunc("/profile", bookManagerServer.HandleProfile)
-	router.HandleFunc("/books", bookManagerServer.HandleBooks)
-	router.HandleFunc("/books/{id:[1-9][0-9]*}", bookManagerServer.HandleOneBook)
-	http.Handle("/", router)
-	logger.WithError(http.ListenAndServe(":8080", nil)).Fatalln("can not run the http server")
 }
@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"bookman/config"
+	"bookman/db"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	SenderLog  = "log"
+	SenderFile = "file"
+)
+
+// Message is a message for a user, such as a password reset code
+type Message struct {
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users. The senders of this package are meant
+// for development, production deployments plug in an email or SMS sender.
+type Notifier interface {
+	Notify(user *db.User, msg Message) error
+}
+
+// New returns the sender configured by cfg, or nil when none is configured.
+// The senders of this package write reset codes in the clear to the log or a
+// file, so they are refused unless insecure senders are allowed.
+func New(cfg config.Config, logger *logrus.Logger) (Notifier, error) {
+	sender := cfg.Notifier.Sender
+	if (sender == SenderLog || sender == SenderFile) && !cfg.Notifier.AllowInsecure {
+		return nil, fmt.Errorf("the notifier sender %q is meant for development and needs NOTIFIER_ALLOW_INSECURE", sender)
+	}
+	switch sender {
+	case SenderLog:
+		return NewLogNotifier(logger), nil
+	case SenderFile:
+		return NewFileNotifier(cfg.Notifier.File), nil
+	case "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier sender %q", sender)
+	}
+}
+
+// LogNotifier logs the messages, bodies included
+type LogNotifier struct {
+	logger *logrus.Logger
+}
+
+func NewLogNotifier(logger *logrus.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) Notify(user *db.User, msg Message) error {
+	n.logger.WithFields(logrus.Fields{
+		"username": user.Username,
+		"subject":  msg.Subject,
+	}).Info(msg.Body)
+	return nil
+}
+
+// FileNotifier appends the messages to a file
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(user *db.User, msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s to %s: %s\n%s\n\n", time.Now().Format(time.RFC3339), user.Username, msg.Subject, msg.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"bookman/config"
+	"bookman/db"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNewRefusesInsecureSenders(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	tests := []struct {
+		sender        string
+		allowInsecure bool
+		valid         bool
+	}{
+		{"", false, true},
+		{SenderLog, false, false},
+		{SenderFile, false, false},
+		{SenderLog, true, true},
+		{SenderFile, true, true},
+		{"carrier-pigeon", true, false},
+	}
+	for _, tt := range tests {
+		var cfg config.Config
+		cfg.Notifier.Sender = tt.sender
+		cfg.Notifier.AllowInsecure = tt.allowInsecure
+		_, err := New(cfg, logger)
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("sender %q allowing insecure %v: valid = %v, want %v (error %v)", tt.sender, tt.allowInsecure, valid, tt.valid, err)
+		}
+	}
+}
+
+func TestNewWithoutSender(t *testing.T) {
+	notifier, err := New(config.Config{}, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notifier != nil {
+		t.Fatalf("got the notifier %T without a sender", notifier)
+	}
+}
+
+func TestLogNotifierLogsTheBody(t *testing.T) {
+	var out strings.Builder
+	logger := logrus.New()
+	logger.SetOutput(&out)
+
+	err := NewLogNotifier(logger).Notify(&db.User{Username: "alice"}, Message{Subject: "Password reset", Body: "the code 1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "the code 1234") {
+		t.Fatalf("the body is not logged: %s", out.String())
+	}
+}